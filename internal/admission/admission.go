@@ -0,0 +1,250 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/window"
+)
+
+const (
+	// shortSamples and longSamples are how many requests the short and long
+	// term latency averages roughly cover.
+	shortSamples = 10
+	longSamples  = 1000
+	// latencyTolerance is how much slower than the long term average the
+	// short term one can get before the concurrency limit is lowered.
+	latencyTolerance = 2
+	// minGradient is the most the concurrency limit is lowered by at once.
+	minGradient = 0.5
+	// smoothing is how much of a new limit is applied at once.
+	smoothing = 0.2
+	// shedWindow is the window the shedding rate is computed over.
+	shedWindow = 10 * time.Second
+)
+
+// ErrShed is returned for requests that are not admitted.
+var ErrShed = errors.New("request shed")
+
+// Priority is the class of a request, each with its own queue.
+type Priority uint8
+
+const (
+	High Priority = iota
+	Normal
+	Low
+	priorities
+)
+
+var priorityNames = [priorities]string{"high", "normal", "low"}
+
+func (p Priority) String() string { return priorityNames[p] }
+
+// ParsePriority parses the name of a priority: high, normal or low.
+func ParsePriority(name string) (Priority, error) {
+	for p, n := range priorityNames {
+		if n == name {
+			return Priority(p), nil
+		}
+	}
+	return Normal, fmt.Errorf("invalid priority %q", name)
+}
+
+type Config struct {
+	// MaxInFlight is the most requests admitted at once.
+	MaxInFlight int
+	// MaxQueue is the most requests of each priority waiting to be admitted.
+	MaxQueue int
+	// QueueTimeout is how long requests wait to be admitted at most.
+	QueueTimeout time.Duration
+	// MinInFlight is the lowest the concurrency limit can be lowered to when
+	// requests get slower, 0 to keep it at MaxInFlight.
+	MinInFlight int
+	// Weights are how many queued requests of each priority are admitted in
+	// turn, so lower priorities still get admitted under load. Missing
+	// weights and weights under 1 count as 1.
+	Weights []int
+}
+
+// Stats are the state and counters of a controller.
+type Stats struct {
+	InFlight int
+	Queued   int
+	Limit    int
+	Admitted uint64
+	Shed     uint64
+	// ShedRate is the percentage of requests shed recently.
+	ShedRate float64
+}
+
+type waiter struct {
+	ready chan struct{}
+}
+
+// New creates an admission controller. Requests are admitted up to a
+// concurrency limit, and queued up to a bound per priority beyond it, to be
+// admitted by weighted round robin over priorities. When adaptive, the limit
+// goes down when requests get slower than their usual latency and slowly
+// grows back otherwise, so the upstream isn't overwhelmed.
+func New(cfg Config) *Controller {
+	var weights [priorities]int
+	for i := range weights {
+		weights[i] = 1
+		if i < len(cfg.Weights) {
+			weights[i] = max(1, cfg.Weights[i])
+		}
+	}
+	return &Controller{
+		cfg:     cfg,
+		weights: weights,
+		limit:   float64(cfg.MaxInFlight),
+		recent:  window.New(shedWindow),
+	}
+}
+
+type Controller struct {
+	cfg     Config
+	weights [priorities]int
+	recent  *window.Counter
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	queues   [priorities][]*waiter
+	queued   int
+	// credits are how many queued requests of each priority can still be
+	// admitted before the next round.
+	credits  [priorities]int
+	admitted uint64
+	shed     uint64
+
+	// short and long term moving averages of the latency, in nanoseconds,
+	// over samples requests so far.
+	short   float64
+	long    float64
+	samples int
+}
+
+// Acquire admits the request, waiting in the queue if needed. The release
+// function must be called with the latency of the request once done.
+func (c *Controller) Acquire(ctx context.Context, prio Priority) (func(time.Duration), error) {
+	c.mu.Lock()
+	if c.inflight < int(c.limit) && c.queued == 0 {
+		c.admit()
+		c.mu.Unlock()
+		return c.release, nil
+	}
+	if len(c.queues[prio]) >= c.cfg.MaxQueue {
+		c.reject()
+		c.mu.Unlock()
+		return nil, ErrShed
+	}
+	w := &waiter{ready: make(chan struct{})}
+	c.queues[prio] = append(c.queues[prio], w)
+	c.queued++
+	c.mu.Unlock()
+
+	timer := time.NewTimer(c.cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return c.release, nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-w.ready:
+		// admitted while giving up.
+		return c.release, nil
+	default:
+	}
+	queue := c.queues[prio]
+	for i, queued := range queue {
+		if queued == w {
+			c.queues[prio] = append(queue[:i], queue[i+1:]...)
+			c.queued--
+			break
+		}
+	}
+	c.reject()
+	return nil, ErrShed
+}
+
+func (c *Controller) admit() {
+	c.inflight++
+	c.admitted++
+	c.recent.Success()
+}
+
+func (c *Controller) reject() {
+	c.shed++
+	c.recent.Failure()
+}
+
+func (c *Controller) release(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	c.adapt(latency)
+	for c.queued > 0 && c.inflight < int(c.limit) {
+		w := c.dequeue()
+		c.admit()
+		close(w.ready)
+	}
+}
+
+// dequeue takes the next queued request, from the highest priority with
+// credits left. Credits are refilled to the weights once spent.
+func (c *Controller) dequeue() *waiter {
+	for {
+		for prio, queue := range c.queues {
+			if len(queue) > 0 && c.credits[prio] > 0 {
+				c.credits[prio]--
+				c.queues[prio] = queue[1:]
+				c.queued--
+				return queue[0]
+			}
+		}
+		c.credits = c.weights
+	}
+}
+
+// adapt adjusts the concurrency limit to the latency of a request, comparing
+// the short term average latency to the long term one: the limit grows while
+// requests are about as fast as usual, and goes down as they get much slower,
+// so it isn't lowered by a mix of fast and slow endpoints.
+func (c *Controller) adapt(latency time.Duration) {
+	if c.cfg.MinInFlight <= 0 || latency <= 0 {
+		return
+	}
+	// until enough requests were made, the averages are plain means.
+	l := float64(latency)
+	c.samples++
+	c.short += (l - c.short) / float64(min(c.samples, shortSamples))
+	c.long += (l - c.long) / float64(min(c.samples, longSamples))
+
+	gradient := max(minGradient, min(1, latencyTolerance*c.long/c.short))
+	target := c.limit*gradient + math.Sqrt(c.limit)
+	limit := c.limit*(1-smoothing) + target*smoothing
+	c.limit = max(float64(c.cfg.MinInFlight), min(float64(c.cfg.MaxInFlight), limit))
+}
+
+func (c *Controller) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		InFlight: c.inflight,
+		Queued:   c.queued,
+		Limit:    int(c.limit),
+		Admitted: c.admitted,
+		Shed:     c.shed,
+		ShedRate: c.recent.Rate(),
+	}
+}
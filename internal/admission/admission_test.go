@@ -0,0 +1,140 @@
+package admission
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestController(t *testing.T) {
+	c := New(Config{MaxInFlight: 2, MaxQueue: 1, QueueTimeout: time.Second})
+
+	release1, err := c.Acquire(context.Background(), Normal)
+	require.NoError(t, err)
+	release2, err := c.Acquire(context.Background(), Normal)
+	require.NoError(t, err)
+
+	admitted := make(chan func(time.Duration))
+	go func() {
+		release, err := c.Acquire(context.Background(), Normal)
+		require.NoError(t, err)
+		admitted <- release
+	}()
+	require.Eventually(t, func() bool { return c.Stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	// the queue is full.
+	_, err = c.Acquire(context.Background(), Normal)
+	require.ErrorIs(t, err, ErrShed)
+
+	release1(time.Millisecond)
+	release3 := <-admitted
+	release2(time.Millisecond)
+	release3(time.Millisecond)
+
+	st := c.Stats()
+	require.Zero(t, st.InFlight)
+	require.Zero(t, st.Queued)
+	require.EqualValues(t, 3, st.Admitted)
+	require.EqualValues(t, 1, st.Shed)
+	require.InDelta(t, 25, st.ShedRate, 0.01)
+}
+
+func TestQueueTimeout(t *testing.T) {
+	c := New(Config{MaxInFlight: 1, MaxQueue: 10, QueueTimeout: 10 * time.Millisecond})
+	release, err := c.Acquire(context.Background(), Normal)
+	require.NoError(t, err)
+
+	_, err = c.Acquire(context.Background(), Normal)
+	require.ErrorIs(t, err, ErrShed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Acquire(ctx, Normal)
+	require.ErrorIs(t, err, ErrShed)
+
+	release(time.Millisecond)
+	st := c.Stats()
+	require.Zero(t, st.Queued)
+	require.EqualValues(t, 2, st.Shed)
+}
+
+func TestAdaptive(t *testing.T) {
+	c := New(Config{MaxInFlight: 500, MaxQueue: 10, QueueTimeout: time.Second, MinInFlight: 10})
+	do := func(latency time.Duration) {
+		release, err := c.Acquire(context.Background(), Normal)
+		require.NoError(t, err)
+		release(latency)
+	}
+
+	// a healthy mix of fast and slow endpoints keeps the limit.
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		do(time.Duration(20+rnd.Intn(280)) * time.Millisecond)
+		require.GreaterOrEqual(t, c.Stats().Limit, 450)
+	}
+	for i := 0; i < 10000; i++ {
+		latency := 50 * time.Millisecond
+		if rnd.Intn(10) == 0 {
+			latency = 2 * time.Second
+		}
+		do(latency)
+	}
+	require.GreaterOrEqual(t, c.Stats().Limit, 400)
+
+	// requests getting much slower lower it.
+	for i := 0; i < 200; i++ {
+		do(5 * time.Second)
+	}
+	require.Equal(t, 10, c.Stats().Limit)
+
+	// and it grows back as they speed up.
+	for i := 0; i < 1000; i++ {
+		do(50 * time.Millisecond)
+	}
+	require.Equal(t, 500, c.Stats().Limit)
+}
+
+func TestPriorities(t *testing.T) {
+	c := New(Config{MaxInFlight: 1, MaxQueue: 10, QueueTimeout: 5 * time.Second, Weights: []int{2, 1}})
+	release, err := c.Acquire(context.Background(), Normal)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	enqueue := func(prio Priority, n int) {
+		for i := 0; i < n; i++ {
+			queued := c.Stats().Queued
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := c.Acquire(context.Background(), prio)
+				require.NoError(t, err)
+				mu.Lock()
+				order = append(order, prio)
+				mu.Unlock()
+				release(time.Millisecond)
+			}()
+			require.Eventually(t, func() bool { return c.Stats().Queued == queued+1 }, time.Second, time.Millisecond)
+		}
+	}
+	enqueue(Low, 3)
+	enqueue(Normal, 2)
+	enqueue(High, 4)
+
+	release(time.Millisecond)
+	wg.Wait()
+	require.Equal(t, []Priority{High, High, Normal, Low, High, High, Normal, Low, Low}, order)
+}
+
+func TestParsePriority(t *testing.T) {
+	prio, err := ParsePriority("low")
+	require.NoError(t, err)
+	require.Equal(t, Low, prio)
+	_, err = ParsePriority("urgent")
+	require.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// UsageHandler serves the usage of every client as JSON, or as CSV with
+// ?format=csv, to requests with the admin token as bearer token.
+func (s *Store) UsageHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		usage := s.Usage()
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+			cw := csv.NewWriter(w)
+			_ = cw.Write([]string{"name", "tier", "day", "today", "total", "over_quota"})
+			for _, u := range usage {
+				_ = cw.Write([]string{
+					u.Name,
+					u.Tier,
+					u.Day,
+					strconv.FormatInt(u.Today, 10),
+					strconv.FormatInt(u.Total, 10),
+					strconv.FormatInt(u.OverQuota, 10),
+				})
+			}
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				slog.Error("could not write usage", "err", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(usage); err != nil {
+			slog.Error("could not write usage", "err", err)
+		}
+	})
+}
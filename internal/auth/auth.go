@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/admission"
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
+)
+
+// Tier is what clients with a key of the tier can do.
+type Tier struct {
+	// Rate limits of each client, 0 for no limit.
+	RateLimit          ratelimit.Rate `json:"rate_limit"`
+	HeavyRateLimit     ratelimit.Rate `json:"heavy_rate_limit"`
+	BroadcastRateLimit ratelimit.Rate `json:"broadcast_rate_limit"`
+	// DailyQuota is how many requests a client can make per day (UTC), 0
+	// for no quota.
+	DailyQuota int64 `json:"daily_quota"`
+	// Kinds are the proxy kinds allowed, rpc and/or rest. Empty to allow
+	// both.
+	Kinds []string `json:"kinds"`
+	// Methods are the JSON-RPC methods allowed, which can have wildcards.
+	// Empty to allow all methods.
+	Methods []string `json:"methods"`
+	// Paths are the REST path prefixes allowed. Empty to allow all paths.
+	Paths []string `json:"paths"`
+	// Priority of the requests of clients when the proxy is saturated: high,
+	// normal or low. Empty to prioritize them by endpoint.
+	Priority string `json:"priority"`
+}
+
+// Key is an API key given to a client.
+type Key struct {
+	Name string `json:"name"`
+	Tier string `json:"tier"`
+}
+
+// File is the format of the API keys file.
+type File struct {
+	Tiers map[string]Tier `json:"tiers"`
+	// Keys are the API keys, by key.
+	Keys map[string]Key `json:"keys"`
+}
+
+// Client is an authenticated client.
+type Client struct {
+	Name     string
+	TierName string
+	Tier     Tier
+}
+
+// Usage is how many requests a client made.
+type Usage struct {
+	Name string `json:"name"`
+	Tier string `json:"tier"`
+	// Day is the day Today is counted for, in UTC.
+	Day   string `json:"day"`
+	Today int64  `json:"today"`
+	Total int64  `json:"total"`
+	// OverQuota is how many requests were rejected for exceeding the daily
+	// quota.
+	OverQuota int64 `json:"over_quota"`
+}
+
+// Load loads the API keys file.
+func Load(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		usage: map[string]*Usage{},
+		now:   time.Now,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Store authenticates clients by API key and keeps track of their usage.
+type Store struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.RWMutex
+	clients map[string]Client
+	modTime time.Time
+
+	usageMu sync.Mutex
+	usage   map[string]*Usage
+}
+
+func (s *Store) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read api keys: %w", err)
+	}
+	bts, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read api keys: %w", err)
+	}
+	var file File
+	if err := json.Unmarshal(bts, &file); err != nil {
+		return fmt.Errorf("could not parse api keys: %w", err)
+	}
+	for name, tier := range file.Tiers {
+		if tier.Priority == "" {
+			continue
+		}
+		if _, err := admission.ParsePriority(tier.Priority); err != nil {
+			return fmt.Errorf("tier %s: %w", name, err)
+		}
+	}
+	clients := make(map[string]Client, len(file.Keys))
+	for key, k := range file.Keys {
+		tier, ok := file.Tiers[k.Tier]
+		if !ok {
+			return fmt.Errorf("api key %s has unknown tier %q", k.Name, k.Tier)
+		}
+		clients[key] = Client{Name: k.Name, TierName: k.Tier, Tier: tier}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients = clients
+	s.modTime = info.ModTime()
+	slog.Info("loaded api keys", "keys", len(clients), "tiers", len(file.Tiers))
+	return nil
+}
+
+// Watch reloads the API keys file when it changes, checking every interval.
+// Invalid files are ignored, keeping the keys loaded before.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				info, err := os.Stat(s.path)
+				if err != nil {
+					slog.Error("could not check api keys", "err", err)
+					continue
+				}
+				s.mu.RLock()
+				changed := !info.ModTime().Equal(s.modTime)
+				s.mu.RUnlock()
+				if !changed {
+					continue
+				}
+				if err := s.load(); err != nil {
+					slog.Error("could not reload api keys", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Lookup finds the client with the given API key.
+func (s *Store) Lookup(key string) (Client, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.clients[key]
+	return c, ok
+}
+
+// Use counts n requests of the client, telling whether they are within its
+// daily quota. Requests over the quota are not counted as used. Usage is only
+// kept in memory, so quotas are per process.
+func (s *Store) Use(c Client, n int) bool {
+	day := s.now().UTC().Format(time.DateOnly)
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	u, ok := s.usage[c.Name]
+	if !ok {
+		u = &Usage{Name: c.Name}
+		s.usage[c.Name] = u
+	}
+	u.Tier = c.TierName
+	if u.Day != day {
+		u.Day, u.Today = day, 0
+	}
+	if c.Tier.DailyQuota > 0 && u.Today+int64(n) > c.Tier.DailyQuota {
+		u.OverQuota += int64(n)
+		return false
+	}
+	u.Today += int64(n)
+	u.Total += int64(n)
+	return true
+}
+
+// QuotaReset returns how long until daily quotas are reset.
+func (s *Store) QuotaReset() time.Duration {
+	now := s.now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// Usage returns the usage of every client that made requests, by name.
+func (s *Store) Usage() []Usage {
+	day := s.now().UTC().Format(time.DateOnly)
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	result := make([]Usage, 0, len(s.usage))
+	for _, u := range s.usage {
+		usage := *u
+		if usage.Day != day {
+			usage.Day, usage.Today = day, 0
+		}
+		result = append(result, usage)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+const keysFile = `{
+	"tiers": {
+		"free": {"rate_limit": "10/s", "daily_quota": 2, "kinds": ["rpc"]},
+		"partner": {"rate_limit": "100/s", "methods": ["status", "abci_*"]}
+	},
+	"keys": {
+		"secret1": {"name": "alice", "tier": "free"},
+		"secret2": {"name": "bob", "tier": "partner"}
+	}
+}`
+
+func writeKeys(tb testing.TB, path, content string) {
+	tb.Helper()
+	require.NoError(tb, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, keysFile)
+
+	s, err := Load(path)
+	require.NoError(t, err)
+
+	alice, ok := s.Lookup("secret1")
+	require.True(t, ok)
+	require.Equal(t, "alice", alice.Name)
+	require.Equal(t, "free", alice.TierName)
+	require.Equal(t, ratelimit.Rate{Requests: 10, Per: time.Second}, alice.Tier.RateLimit)
+	require.Equal(t, []string{"rpc"}, alice.Tier.Kinds)
+	_, ok = s.Lookup("nope")
+	require.False(t, ok)
+
+	t.Run("quota", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+		s.now = func() time.Time { return now }
+		require.True(t, s.Use(alice, 1))
+		require.True(t, s.Use(alice, 1))
+		require.False(t, s.Use(alice, 1))
+		require.Equal(t, time.Hour, s.QuotaReset())
+
+		now = now.Add(time.Hour)
+		require.True(t, s.Use(alice, 2))
+		require.Equal(t, []Usage{{Name: "alice", Tier: "free", Day: "2024-01-02", Today: 2, Total: 4, OverQuota: 1}}, s.Usage())
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.Watch(ctx, 10*time.Millisecond)
+
+		// invalid files are ignored.
+		writeKeys(t, path, `{"keys": {"secret3": {"name": "carol", "tier": "unknown"}}}`)
+		require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+		time.Sleep(50 * time.Millisecond)
+		_, ok := s.Lookup("secret1")
+		require.True(t, ok)
+
+		writeKeys(t, path, `{"tiers": {"free": {}}, "keys": {"secret3": {"name": "carol", "tier": "free"}}}`)
+		require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+		require.Eventually(t, func() bool {
+			_, ok := s.Lookup("secret3")
+			return ok
+		}, time.Second, 10*time.Millisecond)
+		_, ok = s.Lookup("secret1")
+		require.False(t, ok)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+		require.Error(t, err)
+
+		path := filepath.Join(t.TempDir(), "keys.json")
+		writeKeys(t, path, `{"tiers": {"free": {"priority": "urgent"}}}`)
+		_, err = Load(path)
+		require.ErrorContains(t, err, "invalid priority")
+	})
+}
+
+func TestUsageHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, keysFile)
+	s, err := Load(path)
+	require.NoError(t, err)
+	s.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+	bob, _ := s.Lookup("secret2")
+	s.Use(bob, 3)
+
+	srv := httptest.NewServer(s.UsageHandler("admin"))
+	t.Cleanup(srv.Close)
+
+	get := func(t *testing.T, query, token string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+query, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(bts)
+	}
+
+	resp, _ := get(t, "", "")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = get(t, "", "wrong")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, body := get(t, "", "admin")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var usage []Usage
+	require.NoError(t, json.Unmarshal([]byte(body), &usage))
+	require.Equal(t, []Usage{{Name: "bob", Tier: "partner", Day: "2024-01-01", Today: 3, Total: 3}}, usage)
+
+	resp, body = get(t, "?format=csv", "admin")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	require.Equal(t, "name,tier,day,today,total,over_quota\nbob,partner,2024-01-01,3,3,0\n", body)
+}
@@ -0,0 +1,121 @@
+package avg
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	quantileSlots   = 6
+	quantileBuckets = 160
+	quantileGrowth  = 1.1
+	quantileMin     = 100 * time.Microsecond
+)
+
+// Quantiles tracks duration quantiles over a sliding time window.
+//
+// Samples are counted in logarithmic buckets (~10% wide), and the window is
+// split into slots that expire as time passes, so memory is constant no
+// matter how many samples are recorded and old samples decay out of the
+// window instead of sticking around until enough new ones push them out.
+func Quantiles(window time.Duration) *QuantileWindow {
+	slot := window / quantileSlots
+	if slot <= 0 {
+		slot = 1
+	}
+	return &QuantileWindow{
+		slot: slot,
+		now:  time.Now,
+	}
+}
+
+type quantileSlot struct {
+	idx    int64
+	total  uint64
+	counts [quantileBuckets]uint64
+}
+
+type QuantileWindow struct {
+	mu    sync.Mutex
+	slot  time.Duration
+	slots [quantileSlots]quantileSlot
+	now   func() time.Time
+}
+
+func (q *QuantileWindow) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.slots = [quantileSlots]quantileSlot{}
+}
+
+func (q *QuantileWindow) Next(d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idx := q.now().UnixNano() / int64(q.slot)
+	s := &q.slots[idx%quantileSlots]
+	if s.idx != idx {
+		*s = quantileSlot{idx: idx}
+	}
+	s.counts[bucketOf(d)]++
+	s.total++
+}
+
+// Count returns how many samples are currently in the window.
+func (q *QuantileWindow) Count() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idx := q.now().UnixNano() / int64(q.slot)
+	var total uint64
+	for i := range q.slots {
+		if s := &q.slots[i]; s.idx > idx-quantileSlots {
+			total += s.total
+		}
+	}
+	return total
+}
+
+// Quantile returns the upper bound of the bucket holding the given quantile
+// (0-1) of the samples in the window, or 0 if there are none.
+func (q *QuantileWindow) Quantile(quantile float64) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idx := q.now().UnixNano() / int64(q.slot)
+	var counts [quantileBuckets]uint64
+	var total uint64
+	for i := range q.slots {
+		s := &q.slots[i]
+		if s.idx <= idx-quantileSlots {
+			continue
+		}
+		total += s.total
+		for b, c := range s.counts {
+			counts[b] += c
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(quantile * float64(total)))
+	rank = max(1, min(rank, total))
+	var seen uint64
+	for b, c := range counts {
+		seen += c
+		if seen >= rank {
+			return bucketBound(b)
+		}
+	}
+	return bucketBound(quantileBuckets - 1)
+}
+
+func bucketOf(d time.Duration) int {
+	if d <= quantileMin {
+		return 0
+	}
+	b := int(math.Ceil(math.Log(float64(d)/float64(quantileMin)) / math.Log(quantileGrowth)))
+	return min(b, quantileBuckets-1)
+}
+
+func bucketBound(b int) time.Duration {
+	return time.Duration(float64(quantileMin) * math.Pow(quantileGrowth, float64(b)))
+}
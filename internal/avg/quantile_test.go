@@ -0,0 +1,61 @@
+package avg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantiles(t *testing.T) {
+	within := func(t *testing.T, expected, got time.Duration) {
+		t.Helper()
+		require.InEpsilon(t, float64(expected), float64(got), 0.1)
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		q := Quantiles(time.Minute)
+		require.Zero(t, q.Quantile(0.5))
+		require.Zero(t, q.Count())
+	})
+
+	t.Run("tail", func(t *testing.T) {
+		q := Quantiles(time.Minute)
+		for i := 0; i < 98; i++ {
+			q.Next(100 * time.Millisecond)
+		}
+		q.Next(15 * time.Second)
+		q.Next(15 * time.Second)
+		within(t, 100*time.Millisecond, q.Quantile(0.5))
+		within(t, 100*time.Millisecond, q.Quantile(0.9))
+		within(t, 15*time.Second, q.Quantile(0.99))
+		require.Equal(t, uint64(100), q.Count())
+	})
+
+	t.Run("decay", func(t *testing.T) {
+		now := time.Now()
+		q := Quantiles(time.Minute)
+		q.now = func() time.Time { return now }
+		q.Next(time.Second)
+		within(t, time.Second, q.Quantile(0.5))
+
+		now = now.Add(30 * time.Second)
+		q.Next(10 * time.Millisecond)
+		within(t, time.Second, q.Quantile(0.99))
+
+		now = now.Add(40 * time.Second)
+		within(t, 10*time.Millisecond, q.Quantile(0.99))
+		require.Equal(t, uint64(1), q.Count())
+
+		now = now.Add(time.Minute)
+		require.Zero(t, q.Quantile(0.99))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		q := Quantiles(time.Minute)
+		q.Next(time.Second)
+		require.NotZero(t, q.Quantile(0.5))
+		q.Reset()
+		require.Zero(t, q.Quantile(0.5))
+	})
+}
@@ -0,0 +1,275 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	Stored time.Time
+	// TTL is how long the entry is valid for, 0 if it never expires.
+	TTL time.Duration
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return e.TTL > 0 && now.Sub(e.Stored) > e.TTL
+}
+
+func (e Entry) size() int64 {
+	size := int64(len(e.Body))
+	for k, v := range e.Header {
+		size += int64(len(k))
+		for _, vv := range v {
+			size += int64(len(vv))
+		}
+	}
+	return size
+}
+
+type item struct {
+	key   string
+	entry Entry
+}
+
+// file is an entry stored on disk.
+type file struct {
+	name string
+	size int64
+}
+
+// New creates a LRU cache holding up to maxBytes of responses in memory. If
+// dir is not empty, entries that never expire are also stored on disk, up to
+// maxDiskBytes (0 for no limit), and read back from there when evicted from
+// memory.
+func New(maxBytes int64, dir string, maxDiskBytes int64) *Cache {
+	c := &Cache{
+		maxBytes:     maxBytes,
+		maxDiskBytes: maxDiskBytes,
+		items:        map[string]*list.Element{},
+		lru:          list.New(),
+		files:        map[string]*list.Element{},
+		diskLRU:      list.New(),
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			slog.Error("could not create cache dir, not using it", "dir", dir, "err", err)
+			return c
+		}
+		c.dir = dir
+		c.scan()
+	}
+	return c
+}
+
+type Cache struct {
+	maxBytes     int64
+	dir          string
+	maxDiskBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	items map[string]*list.Element
+	lru   *list.List
+
+	// entries stored on disk, by file name, so misses don't read the disk.
+	diskBytes int64
+	files     map[string]*list.Element
+	diskLRU   *list.List
+}
+
+func (c *Cache) Get(key string) (Entry, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		it := el.Value.(*item)
+		if !it.entry.expired(now) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return it.entry, true
+		}
+		c.remove(el)
+	}
+	c.mu.Unlock()
+
+	entry, ok := c.load(key)
+	if !ok {
+		return Entry{}, false
+	}
+	c.add(key, entry)
+	return entry, true
+}
+
+func (c *Cache) Put(key string, entry Entry) {
+	if entry.size() > c.maxBytes {
+		return
+	}
+	c.add(key, entry)
+	if entry.TTL == 0 {
+		c.store(key, entry)
+	}
+}
+
+// Len returns how many entries and bytes are cached in memory.
+func (c *Cache) Len() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.bytes
+}
+
+func (c *Cache) add(key string, entry Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.lru.PushFront(&item{key, entry})
+	c.bytes += entry.size()
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	it := c.lru.Remove(el).(*item)
+	delete(c.items, it.key)
+	c.bytes -= it.entry.size()
+}
+
+// fileName returns the name of the file an entry is stored in on disk.
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// scan indexes the entries stored on disk by a previous run, oldest first, and
+// removes the temporary files of writes that didn't complete.
+func (c *Cache) scan() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		slog.Error("could not read cache dir", "dir", c.dir, "err", err)
+		return
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasSuffix(e.Name(), tmpSuffix) {
+			_ = os.Remove(filepath.Join(c.dir, e.Name()))
+			continue
+		}
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, info := range files {
+		c.index(info.Name(), info.Size())
+	}
+}
+
+// index records a file stored on disk as the most recently used one, removing
+// the least recently used files past maxDiskBytes. c.mu must be held.
+func (c *Cache) index(name string, size int64) {
+	if el, ok := c.files[name]; ok {
+		c.unindex(el)
+	}
+	c.files[name] = c.diskLRU.PushFront(&file{name, size})
+	c.diskBytes += size
+	for c.maxDiskBytes > 0 && c.diskBytes > c.maxDiskBytes {
+		el := c.diskLRU.Back()
+		if err := os.Remove(filepath.Join(c.dir, el.Value.(*file).name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("could not evict cache entry from disk", "err", err)
+		}
+		c.unindex(el)
+	}
+}
+
+func (c *Cache) unindex(el *list.Element) {
+	f := c.diskLRU.Remove(el).(*file)
+	delete(c.files, f.name)
+	c.diskBytes -= f.size
+}
+
+// tmpSuffix is the suffix of the files entries are written to before being
+// renamed, so readers never see partial entries.
+const tmpSuffix = ".tmp"
+
+func (c *Cache) store(key string, entry Entry) {
+	if c.dir == "" {
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		slog.Error("could not encode cache entry", "err", err)
+		return
+	}
+	tmp, err := os.CreateTemp(c.dir, "*"+tmpSuffix)
+	if err != nil {
+		slog.Error("could not store cache entry", "err", err)
+		return
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	name := fileName(key)
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		slog.Error("could not store cache entry", "err", err)
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index(name, int64(buf.Len()))
+}
+
+// load reads an entry back from disk, if it's stored there.
+func (c *Cache) load(key string) (Entry, bool) {
+	var entry Entry
+	name := fileName(key)
+	c.mu.Lock()
+	el, ok := c.files[name]
+	if ok {
+		c.diskLRU.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return entry, false
+	}
+	bts, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err == nil {
+		err = gob.NewDecoder(bytes.NewReader(bts)).Decode(&entry)
+	}
+	if err != nil {
+		slog.Error("could not load cache entry", "err", err)
+		c.mu.Lock()
+		if el, ok := c.files[name]; ok {
+			c.unindex(el)
+		}
+		c.mu.Unlock()
+		return entry, false
+	}
+	return entry, true
+}
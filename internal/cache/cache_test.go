@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	entry := func(body string, ttl time.Duration) Entry {
+		return Entry{
+			Status: http.StatusOK,
+			Header: http.Header{},
+			Body:   []byte(body),
+			Stored: time.Now(),
+			TTL:    ttl,
+		}
+	}
+
+	t.Run("lru", func(t *testing.T) {
+		c := New(10, "", 0)
+		c.Put("a", entry("aaaa", 0))
+		c.Put("b", entry("bbbb", 0))
+		_, ok := c.Get("a")
+		require.True(t, ok)
+		c.Put("c", entry("cccc", 0))
+
+		_, ok = c.Get("b")
+		require.False(t, ok)
+		got, ok := c.Get("a")
+		require.True(t, ok)
+		require.Equal(t, "aaaa", string(got.Body))
+		_, ok = c.Get("c")
+		require.True(t, ok)
+
+		n, size := c.Len()
+		require.Equal(t, 2, n)
+		require.EqualValues(t, 8, size)
+	})
+
+	t.Run("too big", func(t *testing.T) {
+		c := New(10, "", 0)
+		c.Put("a", entry(strings.Repeat("a", 11), 0))
+		_, ok := c.Get("a")
+		require.False(t, ok)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := New(10, "", 0)
+		e := entry("a", time.Second)
+		e.Stored = time.Now().Add(-2 * time.Second)
+		c.Put("a", e)
+		_, ok := c.Get("a")
+		require.False(t, ok)
+		n, _ := c.Len()
+		require.Zero(t, n)
+	})
+
+	t.Run("disk", func(t *testing.T) {
+		dir := t.TempDir()
+		c := New(10, dir, 0)
+		c.Put("immutable", entry("aaaa", 0))
+		c.Put("mutable", entry("bbbb", time.Minute))
+
+		// a new cache, as after a restart.
+		c = New(10, dir, 0)
+		got, ok := c.Get("immutable")
+		require.True(t, ok)
+		require.Equal(t, "aaaa", string(got.Body))
+		_, ok = c.Get("mutable")
+		require.False(t, ok)
+	})
+
+	t.Run("disk eviction", func(t *testing.T) {
+		dir := t.TempDir()
+		c := New(10, dir, 0)
+		c.Put("a", entry("aaaa", 0))
+		size := c.diskBytes
+
+		// only the most recently used entry fits on disk.
+		c = New(4, dir, size)
+		c.Put("b", entry("bbbb", 0))
+		_, ok := c.Get("a")
+		require.False(t, ok)
+		got, ok := c.Get("b")
+		require.True(t, ok)
+		require.Equal(t, "bbbb", string(got.Body))
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+	})
+}
@@ -1,13 +1,22 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
 	"github.com/caarlos0/env/v11"
 )
 
 //go:generate go run github.com/g4s8/envdoc@latest -output ../../config.md -env-prefix AKASH_PROXY_ -types Config
 type Config struct {
+	// YAML file to read the config from, with the names of these variables
+	// without prefix in lowercase as keys (e.g. seed_refresh_interval).
+	// Environment variables override its values.
+	ConfigFile string `env:"CONFIG_FILE"`
+
 	// Address to listen to.
 	Listen string `env:"LISTEN" envDefault:":https"`
 
@@ -32,9 +41,16 @@ type Config struct {
 	// Expected chain ID.
 	ChainID string `env:"CHAIN_ID" envDefault:"akashnet-2"`
 
-	// How slow on average a node needs to be to be marked as unhealthy.
+	// How slow a node needs to be (at HEALTHY_PERCENTILE) to be marked as unhealthy.
 	HealthyThreshold time.Duration `env:"HEALTHY_THRESHOLD" envDefault:"10s"`
 
+	// Which latency percentile (0-100) must be under HEALTHY_THRESHOLD for a
+	// node to be healthy.
+	HealthyPercentile float64 `env:"HEALTHY_PERCENTILE" envDefault:"90"`
+
+	// How long in the past request latencies are considered to compute percentiles.
+	LatencyWindow time.Duration `env:"LATENCY_WINDOW" envDefault:"5m"`
+
 	// Percentage of request errors deemed acceptable.
 	HealthyErrorRateThreshold float64 `env:"HEALTHY_ERROR_RATE_THRESHOLD" envDefault:"30"`
 
@@ -44,15 +60,322 @@ type Config struct {
 	// Request timeout for a proxied request.
 	ProxyRequestTimeout time.Duration `env:"PROXY_REQUEST_TIMEOUT" envDefault:"15s"`
 
+	// How frequently nodes are compared to the rest of the pool to eject
+	// outliers. Set to 0 to disable outlier detection.
+	OutlierDetectionInterval time.Duration `env:"OUTLIER_DETECTION_INTERVAL" envDefault:"10s"`
+
+	// How many times slower than the pool median (at HEALTHY_PERCENTILE) a
+	// node needs to be to be ejected.
+	OutlierLatencyFactor float64 `env:"OUTLIER_LATENCY_FACTOR" envDefault:"3"`
+
+	// How many standard deviations above the pool mean error rate a node
+	// needs to be to be ejected.
+	OutlierErrorRateStdDev float64 `env:"OUTLIER_ERROR_RATE_STDDEV" envDefault:"2"`
+
+	// How many requests in LATENCY_WINDOW a node needs to be considered for
+	// outlier detection.
+	OutlierMinRequests int `env:"OUTLIER_MIN_REQUESTS" envDefault:"10"`
+
+	// How long an outlier is ejected for, multiplied by how many times in a
+	// row it was ejected.
+	OutlierBaseEjectionTime time.Duration `env:"OUTLIER_BASE_EJECTION_TIME" envDefault:"30s"`
+
+	// Maximum percentage (0-100) of the pool that can be ejected at once.
+	OutlierMaxEjectionPct int `env:"OUTLIER_MAX_EJECTION_PERCENT" envDefault:"50"`
+
+	// How long newly added or recovered nodes take to ramp up to their full
+	// share of requests.
+	SlowStartDuration time.Duration `env:"SLOW_START_DURATION" envDefault:"30s"`
+
+	// How many requests newly added or recovered nodes need to serve before
+	// they are considered warmed up.
+	SlowStartRequests int `env:"SLOW_START_REQUESTS" envDefault:"10"`
+
+	// How frequently nodes are probed for their status, like the range of
+	// blocks they retain. Set to 0 to disable probing.
+	StatusProbeInterval time.Duration `env:"STATUS_PROBE_INTERVAL" envDefault:"30s"`
+
+	// How frequently REST nodes are probed for the modules and features they
+	// support.
+	CapabilityProbeInterval time.Duration `env:"CAPABILITY_PROBE_INTERVAL" envDefault:"10m"`
+
+	// Session affinity to keep clients on the same node while it's healthy:
+	// empty (disabled), cookie, header or ip.
+	Affinity string `env:"AFFINITY"`
+
+	// Cookie used to keep track of sessions with cookie affinity.
+	AffinityCookie string `env:"AFFINITY_COOKIE" envDefault:"akash_proxy_session"`
+
+	// Whether the session cookie is only sent over HTTPS. Set to false when
+	// clients reach the proxy over plain HTTP.
+	AffinityCookieSecure bool `env:"AFFINITY_COOKIE_SECURE" envDefault:"true"`
+
+	// Header clients use to identify their session with header affinity.
+	AffinityHeader string `env:"AFFINITY_HEADER" envDefault:"X-Session-ID"`
+
+	// How long a session is kept on the same node after its last request.
+	AffinityTTL time.Duration `env:"AFFINITY_TTL" envDefault:"10m"`
+
+	// Most sessions kept at once, 0 for no limit. Clients beyond it are
+	// balanced as if they had no session until others expire.
+	AffinityMaxSessions int `env:"AFFINITY_MAX_SESSIONS" envDefault:"100000"`
+
+	// How many nodes transactions are broadcasted to in parallel.
+	BroadcastFanout int `env:"BROADCAST_FANOUT" envDefault:"3"`
+
+	// Trusted RPC nodes to broadcast transactions to, instead of nodes from
+	// the pool.
+	RPCBroadcastServers []string `env:"RPC_BROADCAST_SERVERS"`
+
+	// Trusted REST nodes to broadcast transactions to, instead of nodes from
+	// the pool.
+	RestBroadcastServers []string `env:"REST_BROADCAST_SERVERS"`
+
+	// Whether to inspect responses for node errors hidden in successful
+	// replies, like JSON-RPC errors or gRPC-gateway statuses.
+	InspectResponses bool `env:"INSPECT_RESPONSES" envDefault:"true"`
+
+	// How many other nodes to try when a node fails a request in a way another
+	// node could answer it (e.g. pruned height, connection refused).
+	RetryAttempts int `env:"RETRY_ATTEMPTS" envDefault:"2"`
+
+	// Maximum size in bytes of the responses of nodes buffered in memory, e.g.
+	// to inspect or cache them. Larger responses are streamed to clients
+	// unread, or replied with 502 when they must be read, like the ones of
+	// coalesced requests or split batches. Set to 0 for no limit.
+	MaxResponseSize int64 `env:"MAX_RESPONSE_SIZE" envDefault:"10485760"`
+
+	// Maximum size in bytes of the cache of responses that don't change, like
+	// blocks and txs, for each kind of proxy. Set to 0 to disable caching.
+	CacheSize int64 `env:"CACHE_SIZE" envDefault:"67108864"`
+
+	// Directory to also store cached responses that never change in, so they
+	// survive restarts. Empty to only cache them in memory.
+	CacheDir string `env:"CACHE_DIR"`
+
+	// Maximum size in bytes of the responses stored in CACHE_DIR for each kind
+	// of proxy, past which the least recently used ones are removed. Set to 0
+	// for no limit.
+	CacheDiskMaxBytes int64 `env:"CACHE_DISK_MAX_BYTES" envDefault:"1073741824"`
+
+	// How long responses of endpoints in CACHE_MUTABLE_PATHS are cached. Set
+	// to 0 to not cache them.
+	CacheMutableTTL time.Duration `env:"CACHE_MUTABLE_TTL" envDefault:"1s"`
+
+	// Endpoints whose responses change but can be cached for CACHE_MUTABLE_TTL.
+	CacheMutablePaths []string `env:"CACHE_MUTABLE_PATHS" envDefault:"/status,/abci_info,/cosmos/base/tendermint/v1beta1/blocks/latest,/cosmos/base/tendermint/v1beta1/node_info"`
+
+	// Endpoints whose identical concurrent requests share a single call to a
+	// node. JSON-RPC calls sent in a POST body are matched by method, e.g.
+	// /status.
+	CoalescePaths []string `env:"COALESCE_PATHS" envDefault:"/status,/abci_info,/cosmos/base/tendermint/v1beta1/blocks/latest,/cosmos/base/tendermint/v1beta1/node_info,/cosmos/base/tendermint/v1beta1/syncing"`
+
+	// Whether to split JSON-RPC batches into single calls that can be served
+	// by different nodes, reassembling their replies.
+	RPCBatchSplit bool `env:"RPC_BATCH_SPLIT"`
+
+	// Maximum number of calls in a JSON-RPC batch. Set to 0 for no limit.
+	RPCMaxBatchSize int `env:"RPC_MAX_BATCH_SIZE" envDefault:"100"`
+
+	// JSON-RPC methods allowed, which can have wildcards (e.g. abci_*). Empty
+	// to allow all methods but the denied ones.
+	RPCAllowMethods []string `env:"RPC_ALLOW_METHODS"`
+
+	// JSON-RPC methods denied, which can have wildcards (e.g. unsafe_*).
+	RPCDenyMethods []string `env:"RPC_DENY_METHODS" envDefault:"dial_seeds,dial_peers,unsafe_*"`
+
+	// Maximum per_page of paginated JSON-RPC methods, like tx_search. Set to 0
+	// for no limit.
+	RPCMaxPerPage int `env:"RPC_MAX_PER_PAGE"`
+
+	// Maximum number of conditions in tx_search and block_search queries. Set
+	// to 0 for no limit.
+	RPCMaxQueryConditions int `env:"RPC_MAX_QUERY_CONDITIONS" envDefault:"5"`
+
+	// REST path prefixes allowed, e.g. /cosmos/bank/. Empty to allow all
+	// paths.
+	RestAllowPaths []string `env:"REST_ALLOW_PATHS"`
+
+	// REST HTTP methods allowed. Empty to allow all methods.
+	RestAllowMethods []string `env:"REST_ALLOW_METHODS"`
+
+	// Maximum pagination.limit of REST queries. Set to 0 for no limit.
+	RestMaxPaginationLimit int `env:"REST_MAX_PAGINATION_LIMIT"`
+
+	// Whether to lower pagination.limit to REST_MAX_PAGINATION_LIMIT instead of
+	// rejecting queries over it.
+	RestClampPaginationLimit bool `env:"REST_CLAMP_PAGINATION_LIMIT" envDefault:"true"`
+
+	// REST query params rejected, either with any value (e.g. name) or with
+	// a given one (e.g. name=value).
+	RestBlockedParams []string `env:"REST_BLOCKED_PARAMS" envDefault:"pagination.count_total=true"`
+
+	// IPs or CIDRs of proxies in front of this one, whose X-Forwarded-For and
+	// X-Real-IP headers are trusted to find the client IP.
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+
+	// Header identifying clients for rate limiting, like an API key set by a
+	// gateway in front of the proxy. Only honored from TRUSTED_PROXIES, and
+	// clients are identified by IP if empty or not set.
+	RateLimitHeader string `env:"RATE_LIMIT_HEADER"`
+
+	// Rate limit of each client on RPC, e.g. 20/s or 600/m. Empty or 0 for no
+	// limit.
+	RPCRateLimit ratelimit.Rate `env:"RPC_RATE_LIMIT"`
+
+	// Rate limit of each client on expensive RPC methods, like tx_search.
+	// Empty or 0 for no limit.
+	RPCHeavyRateLimit ratelimit.Rate `env:"RPC_HEAVY_RATE_LIMIT"`
+
+	// Rate limit of each client broadcasting transactions on RPC. Empty or 0
+	// for no limit.
+	RPCBroadcastRateLimit ratelimit.Rate `env:"RPC_BROADCAST_RATE_LIMIT"`
+
+	// Rate limit of each client on REST, e.g. 20/s or 600/m. Empty or 0 for
+	// no limit.
+	RestRateLimit ratelimit.Rate `env:"REST_RATE_LIMIT"`
+
+	// Rate limit of each client on expensive REST endpoints, like tx search.
+	// Empty or 0 for no limit.
+	RestHeavyRateLimit ratelimit.Rate `env:"REST_HEAVY_RATE_LIMIT"`
+
+	// Rate limit of each client broadcasting transactions on REST. Empty or 0
+	// for no limit.
+	RestBroadcastRateLimit ratelimit.Rate `env:"REST_BROADCAST_RATE_LIMIT"`
+
+	// JSON file with the API keys clients can authenticate with, and their
+	// tiers. Empty to not authenticate clients. Usage and daily quotas are
+	// counted in memory, per process.
+	APIKeysFile string `env:"API_KEYS_FILE"`
+
+	// Whether clients need an API key. Clients without one get the default
+	// rate limits otherwise.
+	APIKeysRequired bool `env:"API_KEYS_REQUIRED"`
+
+	// How frequently API_KEYS_FILE is checked for changes.
+	APIKeysReloadInterval time.Duration `env:"API_KEYS_RELOAD_INTERVAL" envDefault:"30s"`
+
+	// Bearer token of the admin endpoints, like /admin/usage. Empty to disable
+	// them.
+	AdminToken string `env:"ADMIN_TOKEN"`
+
+	// Origins allowed to make cross-origin requests to RPC, * for any origin.
+	// Empty to disallow cross-origin requests.
+	RPCCORSOrigins []string `env:"RPC_CORS_ORIGINS" envDefault:"*"`
+
+	// Methods allowed in cross-origin requests to RPC.
+	RPCCORSMethods []string `env:"RPC_CORS_METHODS" envDefault:"GET,POST,OPTIONS"`
+
+	// Headers allowed in cross-origin requests to RPC.
+	RPCCORSHeaders []string `env:"RPC_CORS_HEADERS" envDefault:"Content-Type,X-API-Key,X-Min-Height,X-Session-ID"`
+
+	// Origins allowed to make cross-origin requests to REST, * for any origin.
+	// Empty to disallow cross-origin requests.
+	RestCORSOrigins []string `env:"REST_CORS_ORIGINS" envDefault:"*"`
+
+	// Methods allowed in cross-origin requests to REST.
+	RestCORSMethods []string `env:"REST_CORS_METHODS" envDefault:"GET,POST,OPTIONS"`
+
+	// Headers allowed in cross-origin requests to REST.
+	RestCORSHeaders []string `env:"REST_CORS_HEADERS" envDefault:"Content-Type,X-API-Key,X-Min-Height,X-Session-ID,X-Cosmos-Block-Height"`
+
+	// How long browsers can cache preflight responses.
+	CORSMaxAge time.Duration `env:"CORS_MAX_AGE" envDefault:"10m"`
+
+	// Maximum rate of requests sent to each node, e.g. 1000/m, so no single
+	// operator is abused. Nodes that spent it are skipped until the end of the
+	// period. Set to 0 for no limit.
+	UpstreamRequestBudget ratelimit.Rate `env:"UPSTREAM_REQUEST_BUDGET" envDefault:"0"`
+
+	// Maximum rate of requests sent to each provider, across its RPC and REST
+	// nodes. Set to 0 for no limit.
+	ProviderRequestBudget ratelimit.Rate `env:"PROVIDER_REQUEST_BUDGET" envDefault:"0"`
+
+	// How long a node replying 429 without telling when to retry is not sent
+	// requests for.
+	CooldownDefault time.Duration `env:"COOLDOWN_DEFAULT" envDefault:"10s"`
+
+	// Maximum time a node is not sent requests for after rate limiting the
+	// proxy, whatever its Retry-After header says.
+	CooldownMax time.Duration `env:"COOLDOWN_MAX" envDefault:"5m"`
+
+	// Maximum number of requests proxied at once by each kind of proxy, e.g.
+	// 500. Set to 0 for no limit, not shedding any load.
+	MaxInFlight int `env:"MAX_IN_FLIGHT"`
+
+	// Maximum number of requests of each priority waiting for others to finish
+	// when MAX_IN_FLIGHT is reached. Requests beyond it are rejected right away.
+	MaxQueue int `env:"MAX_QUEUE" envDefault:"1000"`
+
+	// How long requests wait in the queue at most before being rejected.
+	QueueTimeout time.Duration `env:"QUEUE_TIMEOUT" envDefault:"5s"`
+
+	// Lowest the number of requests proxied at once can be lowered to when
+	// nodes get slower, e.g. 10. Set to 0 to always allow MAX_IN_FLIGHT.
+	MinInFlight int `env:"MIN_IN_FLIGHT"`
+
+	// How many queued requests of high, normal and low priority are admitted
+	// in turn when MAX_IN_FLIGHT is reached.
+	PriorityWeights []int `env:"PRIORITY_WEIGHTS" envDefault:"8,4,1"`
+
+	// Endpoints whose requests have high priority, which can have wildcards
+	// (e.g. /cosmos/bank/v1beta1/balances/*). JSON-RPC calls are matched by
+	// method, e.g. /abci_query. Transaction broadcasts always have high
+	// priority.
+	HighPriorityPaths []string `env:"HIGH_PRIORITY_PATHS" envDefault:"/abci_query,/cosmos/bank/v1beta1/balances/*,/cosmos/auth/v1beta1/accounts/*"`
+
+	// Endpoints whose requests have low priority, which can have wildcards.
+	LowPriorityPaths []string `env:"LOW_PRIORITY_PATHS" envDefault:"/block_results,/tx_search,/block_search,/cosmos/tx/v1beta1/txs,/cosmos/tx/v1beta1/txs/block/*"`
+
+	// Header with the priority of requests (high, normal or low), set by a
+	// gateway in front of the proxy. Only honored from TRUSTED_PROXIES, and
+	// never above the priority of the API key's tier. Empty to ignore it.
+	PriorityHeader string `env:"PRIORITY_HEADER"`
+
+	// Percentage of requests rejected for overload (0-100) above which the
+	// proxy reports not being ready.
+	UnreadyShedPct float64 `env:"UNREADY_SHED_PERCENT" envDefault:"10"`
+
 	// How much chance (in %, 0-100), a node marked as unhealthy have to get a
 	// request again and recover.
 	UnhealthyServerRecoverChancePct int `env:"UNHEALTHY_SERVER_RECOVERY_CHANCE_PERCENT" envDefault:"1"`
 }
 
-func Must() Config {
+// prefix is the prefix of the environment variables of the config.
+const prefix = "AKASH_PROXY_"
+
+// Load reads the config from the environment variables, over the values of
+// CONFIG_FILE if set, and validates it.
+func Load() (Config, error) {
+	environ := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			environ[k] = v
+		}
+	}
+	if path := environ[prefix+"CONFIG_FILE"]; path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		for k, v := range values {
+			if _, ok := environ[prefix+k]; !ok {
+				environ[prefix+k] = v
+			}
+		}
+	}
 	cfg, err := env.ParseAsWithOptions[Config](env.Options{
-		Prefix: "AKASH_PROXY_",
+		Prefix:      prefix,
+		Environment: environ,
 	})
+	if err != nil {
+		return Config{}, fmt.Errorf("could not parse config: %w", err)
+	}
+	return cfg, cfg.Validate()
+}
+
+func Must() Config {
+	cfg, err := Load()
 	if err != nil {
 		panic("could not get config: " + err.Error())
 	}
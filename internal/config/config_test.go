@@ -1,8 +1,12 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,4 +19,56 @@ func TestTest(t *testing.T) {
 	require.NotZero(t, cfg.HealthyThreshold)
 	require.NotZero(t, cfg.ProxyRequestTimeout)
 	require.NotZero(t, cfg.UnhealthyServerRecoverChancePct)
+	require.False(t, cfg.RPCRateLimit.Enabled())
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+seed_refresh_interval: 1m
+healthy_percentile: 99
+rpc_deny_methods: [unsafe_*, dial_*]
+rpc_rate_limit: 5/s
+chain_id: testnet-1
+`), 0o600))
+	t.Setenv("AKASH_PROXY_CONFIG_FILE", path)
+	t.Setenv("AKASH_PROXY_CHAIN_ID", "akashnet-2")
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, cfg.SeedRefreshInterval)
+	require.EqualValues(t, 99, cfg.HealthyPercentile)
+	require.Equal(t, []string{"unsafe_*", "dial_*"}, cfg.RPCDenyMethods)
+	require.Equal(t, ratelimit.Rate{Requests: 5, Per: time.Second}, cfg.RPCRateLimit)
+	// env vars override the file.
+	require.Equal(t, "akashnet-2", cfg.ChainID)
+	// defaults are kept for the rest.
+	require.Equal(t, 10*time.Second, cfg.HealthyThreshold)
+
+	require.NoError(t, os.WriteFile(path, []byte("seed_refresh_intervall: 1m\n"), 0o600))
+	_, err = Load()
+	require.ErrorContains(t, err, `unknown key "seed_refresh_intervall"`)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Must()
+	require.NoError(t, cfg.Validate())
+
+	cfg.SeedRefreshInterval = 0
+	cfg.UnhealthyServerRecoverChancePct = 500
+	cfg.TLSCert = "cert.pem"
+	cfg.Affinity = "sticky"
+	cfg.AffinityTTL = 0
+	cfg.AffinityMaxSessions = -1
+	err := cfg.Validate()
+	require.ErrorContains(t, err, "SEED_REFRESH_INTERVAL must be positive")
+	require.ErrorContains(t, err, "UNHEALTHY_SERVER_RECOVERY_CHANCE_PERCENT must be between 0 and 100, got 500")
+	require.ErrorContains(t, err, "TLS_CERT and TLS_KEY must be set together")
+	require.ErrorContains(t, err, `AFFINITY "sticky" must be empty, cookie, header or ip`)
+	require.ErrorContains(t, err, "AFFINITY_TTL must be positive with AFFINITY")
+	require.ErrorContains(t, err, "AFFINITY_MAX_SESSIONS must not be negative, got -1")
+
+	t.Setenv("AKASH_PROXY_HEALTHY_PERCENTILE", "101")
+	_, err = Load()
+	require.ErrorContains(t, err, "HEALTHY_PERCENTILE must be between 0 and 100")
 }
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readFile reads a YAML config file into the values of the environment
+// variables it sets, without prefix, so they are parsed the same way.
+func readFile(path string) (map[string]string, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	var file map[string]any
+	if err := yaml.Unmarshal(bts, &file); err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	known := keys()
+	values := make(map[string]string, len(file))
+	for key, value := range file {
+		name := strings.ToUpper(key)
+		if !known[name] || name == "CONFIG_FILE" {
+			return nil, fmt.Errorf("config file %s: unknown key %q", path, key)
+		}
+		switch value := value.(type) {
+		case nil:
+			values[name] = ""
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("config file %s: %s must be a value or a list", path, key)
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// keys returns the names of the environment variables of the config, without
+// prefix.
+func keys() map[string]bool {
+	result := map[string]bool{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("env"), ","); name != "" {
+			result[name] = true
+		}
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// Validate checks the config makes sense, returning every problem found.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Listen != "", "LISTEN must be set")
+	check((c.TLSCert == "") == (c.TLSKey == ""), "TLS_CERT and TLS_KEY must be set together")
+	u, err := url.Parse(c.SeedURL)
+	check(err == nil && u.Scheme != "" && u.Host != "", "SEED_URL %q must be a valid URL", c.SeedURL)
+	check(c.Affinity == "" || c.Affinity == "cookie" || c.Affinity == "header" || c.Affinity == "ip",
+		"AFFINITY %q must be empty, cookie, header or ip", c.Affinity)
+	check(c.Affinity == "" || c.AffinityTTL > 0, "AFFINITY_TTL must be positive with AFFINITY, got %s", c.AffinityTTL)
+	check(c.AffinityMaxSessions >= 0, "AFFINITY_MAX_SESSIONS must not be negative, got %d", c.AffinityMaxSessions)
+	check(c.APIKeysFile != "" || !c.APIKeysRequired, "API_KEYS_REQUIRED needs API_KEYS_FILE")
+	for _, addr := range c.TrustedProxies {
+		_, perr := netip.ParsePrefix(addr)
+		_, aerr := netip.ParseAddr(addr)
+		check(perr == nil || aerr == nil, "TRUSTED_PROXIES %q must be an IP or a CIDR", addr)
+	}
+
+	for _, f := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"SEED_REFRESH_INTERVAL", c.SeedRefreshInterval},
+		{"HEALTHY_THRESHOLD", c.HealthyThreshold},
+		{"LATENCY_WINDOW", c.LatencyWindow},
+		{"HEALTHY_ERROR_RATE_BUCKET_TIMEOUT", c.HealthyErrorRateBucketTimeout},
+		{"PROXY_REQUEST_TIMEOUT", c.ProxyRequestTimeout},
+	} {
+		check(f.d > 0, "%s must be positive, got %s", f.name, f.d)
+	}
+	for _, f := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"OUTLIER_DETECTION_INTERVAL", c.OutlierDetectionInterval},
+		{"OUTLIER_BASE_EJECTION_TIME", c.OutlierBaseEjectionTime},
+		{"SLOW_START_DURATION", c.SlowStartDuration},
+		{"STATUS_PROBE_INTERVAL", c.StatusProbeInterval},
+		{"CAPABILITY_PROBE_INTERVAL", c.CapabilityProbeInterval},
+		{"AFFINITY_TTL", c.AffinityTTL},
+		{"CACHE_MUTABLE_TTL", c.CacheMutableTTL},
+		{"CORS_MAX_AGE", c.CORSMaxAge},
+		{"COOLDOWN_DEFAULT", c.CooldownDefault},
+		{"COOLDOWN_MAX", c.CooldownMax},
+	} {
+		check(f.d >= 0, "%s must not be negative, got %s", f.name, f.d)
+	}
+	check(c.APIKeysFile == "" || c.APIKeysReloadInterval > 0, "API_KEYS_RELOAD_INTERVAL must be positive, got %s", c.APIKeysReloadInterval)
+	check(c.MaxInFlight == 0 || c.QueueTimeout > 0, "QUEUE_TIMEOUT must be positive, got %s", c.QueueTimeout)
+	check(c.CooldownDefault <= c.CooldownMax, "COOLDOWN_DEFAULT must not exceed COOLDOWN_MAX")
+
+	for _, f := range []struct {
+		name string
+		pct  float64
+	}{
+		{"HEALTHY_PERCENTILE", c.HealthyPercentile},
+		{"HEALTHY_ERROR_RATE_THRESHOLD", c.HealthyErrorRateThreshold},
+		{"OUTLIER_MAX_EJECTION_PERCENT", float64(c.OutlierMaxEjectionPct)},
+		{"UNREADY_SHED_PERCENT", c.UnreadyShedPct},
+		{"UNHEALTHY_SERVER_RECOVERY_CHANCE_PERCENT", float64(c.UnhealthyServerRecoverChancePct)},
+	} {
+		check(f.pct >= 0 && f.pct <= 100, "%s must be between 0 and 100, got %v", f.name, f.pct)
+	}
+	for _, f := range []struct {
+		name string
+		n    int64
+	}{
+		{"OUTLIER_MIN_REQUESTS", int64(c.OutlierMinRequests)},
+		{"SLOW_START_REQUESTS", int64(c.SlowStartRequests)},
+		{"RETRY_ATTEMPTS", int64(c.RetryAttempts)},
+		{"MAX_RESPONSE_SIZE", c.MaxResponseSize},
+		{"CACHE_SIZE", c.CacheSize},
+		{"CACHE_DISK_MAX_BYTES", c.CacheDiskMaxBytes},
+		{"RPC_MAX_BATCH_SIZE", int64(c.RPCMaxBatchSize)},
+		{"RPC_MAX_PER_PAGE", int64(c.RPCMaxPerPage)},
+		{"RPC_MAX_QUERY_CONDITIONS", int64(c.RPCMaxQueryConditions)},
+		{"REST_MAX_PAGINATION_LIMIT", int64(c.RestMaxPaginationLimit)},
+		{"MAX_IN_FLIGHT", int64(c.MaxInFlight)},
+		{"MAX_QUEUE", int64(c.MaxQueue)},
+		{"MIN_IN_FLIGHT", int64(c.MinInFlight)},
+	} {
+		check(f.n >= 0, "%s must not be negative, got %d", f.name, f.n)
+	}
+	check(c.OutlierLatencyFactor > 0, "OUTLIER_LATENCY_FACTOR must be positive, got %v", c.OutlierLatencyFactor)
+	check(c.OutlierErrorRateStdDev >= 0, "OUTLIER_ERROR_RATE_STDDEV must not be negative, got %v", c.OutlierErrorRateStdDev)
+	check(c.BroadcastFanout > 0, "BROADCAST_FANOUT must be positive, got %d", c.BroadcastFanout)
+	check(c.MaxInFlight == 0 || c.MinInFlight <= c.MaxInFlight, "MIN_IN_FLIGHT must not exceed MAX_IN_FLIGHT")
+	check(len(c.PriorityWeights) <= 3, "PRIORITY_WEIGHTS must have at most 3 weights, for high, normal and low priority")
+	for _, w := range c.PriorityWeights {
+		check(w > 0, "PRIORITY_WEIGHTS must be positive, got %d", w)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/admission"
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
+)
+
+const rpcOverloaded = -32002
+
+// admit waits for the request to be admitted by the admission controller,
+// rejecting it with 503 if the proxy is overloaded. The returned function must
+// be called once the request is done.
+func (p *Proxy) admit(w http.ResponseWriter, req *request) (func(), bool) {
+	if p.admission == nil {
+		return func() {}, true
+	}
+	release, err := p.admission.Acquire(req.Context(), p.priority(req))
+	if err != nil {
+		slog.Warn("request shed", "err", err)
+		w.Header().Set("Retry-After", "1")
+		p.fail(w, req, http.StatusServiceUnavailable, rpcOverloaded, grpcUnavailable, "proxy is overloaded, retry later")
+		return nil, false
+	}
+	start := time.Now()
+	return func() { release(time.Since(start)) }, true
+}
+
+func (p *Proxy) admissionStats() admission.Stats {
+	if p.admission == nil {
+		return admission.Stats{}
+	}
+	return p.admission.Stats()
+}
+
+// newAdmission creates the admission controller of a proxy, nil if disabled.
+func newAdmission(cfg config.Config) *admission.Controller {
+	if cfg.MaxInFlight <= 0 {
+		return nil
+	}
+	return admission.New(admission.Config{
+		MaxInFlight:  cfg.MaxInFlight,
+		MaxQueue:     cfg.MaxQueue,
+		QueueTimeout: cfg.QueueTimeout,
+		MinInFlight:  cfg.MinInFlight,
+		Weights:      cfg.PriorityWeights,
+	})
+}
+
+// priority classifies the request, from the tier of its API key, the priority
+// header set by a trusted proxy, or the endpoints it calls. The header can't
+// raise the priority above the tier's. Broadcasts have high priority, and
+// batches the lowest priority of their calls.
+func (p *Proxy) priority(req *request) admission.Priority {
+	tier, hasTier := admission.High, false
+	if req.client != nil && req.client.Tier.Priority != "" {
+		if prio, err := admission.ParsePriority(req.client.Tier.Priority); err == nil {
+			tier, hasTier = prio, true
+		}
+	}
+	if p.cfg.PriorityHeader != "" && ratelimit.FromTrusted(req.Request, p.trusted) {
+		if prio, err := admission.ParsePriority(req.Header.Get(p.cfg.PriorityHeader)); err == nil {
+			return max(prio, tier)
+		}
+	}
+	if hasTier {
+		return tier
+	}
+	if req.broadcast {
+		return admission.High
+	}
+	endpoints := []string{req.URL.Path}
+	if p.kind == RPC && len(req.calls) > 0 {
+		endpoints = endpoints[:0]
+		for _, call := range req.calls {
+			endpoints = append(endpoints, "/"+call.Method)
+		}
+	}
+	prio := admission.High
+	for _, endpoint := range endpoints {
+		switch {
+		case matchPattern(p.cfg.LowPriorityPaths, endpoint):
+			return admission.Low
+		case !matchPattern(p.cfg.HighPriorityPaths, endpoint):
+			prio = admission.Normal
+		}
+	}
+	return prio
+}
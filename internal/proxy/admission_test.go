@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/admission"
+	"github.com/akash-network/rpc-proxy/internal/auth"
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShed(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	}))
+	t.Cleanup(node.Close)
+	t.Cleanup(unblock)
+
+	cfg := testConfig()
+	cfg.ProxyRequestTimeout = 5 * time.Second
+	cfg.MaxInFlight = 1
+	cfg.MaxQueue = 0
+	cfg.QueueTimeout = time.Second
+	cfg.UnreadyShedPct = 10
+	proxy := New(RPC, nil, cfg)
+	require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: node.URL, Provider: "node"}}))
+	require.True(t, proxy.Ready())
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(proxySrv.URL + "/net_info")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}()
+	require.Eventually(t, func() bool { return hits.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	resp, err := http.Post(proxySrv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"status"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+	var reply rpcReply
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.JSONEq(t, "7", string(reply.ID))
+	require.Equal(t, rpcOverloaded, reply.Error.Code)
+
+	unblock()
+	<-done
+	st := proxy.ProxyStats().Admission
+	require.EqualValues(t, 1, st.Admitted)
+	require.EqualValues(t, 1, st.Shed)
+	require.False(t, proxy.Ready())
+}
+
+func TestPriority(t *testing.T) {
+	cfg := config.Config{
+		HighPriorityPaths: []string{"/abci_query", "/cosmos/bank/v1beta1/balances/*"},
+		LowPriorityPaths:  []string{"/block_results", "/cosmos/tx/v1beta1/txs"},
+		PriorityHeader:    "X-Priority",
+		TrustedProxies:    []string{"192.0.2.1"},
+	}
+	rpc := New(RPC, nil, cfg)
+	rest := New(Rest, nil, cfg)
+
+	for name, tt := range map[string]struct {
+		proxy  *Proxy
+		method string
+		target string
+		body   string
+		header string
+		tier   string
+		want   admission.Priority
+	}{
+		"rpc high":          {rpc, http.MethodGet, "/abci_query?path=x", "", "", "", admission.High},
+		"rpc low":           {rpc, http.MethodGet, "/block_results?height=1", "", "", "", admission.Low},
+		"rpc normal":        {rpc, http.MethodGet, "/status", "", "", "", admission.Normal},
+		"rpc broadcast":     {rpc, http.MethodGet, "/broadcast_tx_sync?tx=0x00", "", "", "", admission.High},
+		"rpc batch":         {rpc, http.MethodPost, "/", `[{"jsonrpc":"2.0","id":1,"method":"abci_query"},{"jsonrpc":"2.0","id":2,"method":"status"}]`, "", "", admission.Normal},
+		"rpc batch low":     {rpc, http.MethodPost, "/", `[{"jsonrpc":"2.0","id":1,"method":"abci_query"},{"jsonrpc":"2.0","id":2,"method":"block_results"}]`, "", "", admission.Low},
+		"rest high":         {rest, http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", "", "", "", admission.High},
+		"rest low":          {rest, http.MethodGet, "/cosmos/tx/v1beta1/txs?query=x", "", "", "", admission.Low},
+		"rest broadcast":    {rest, http.MethodPost, "/cosmos/tx/v1beta1/txs", `{}`, "", "", admission.High},
+		"header":            {rest, http.MethodGet, "/cosmos/tx/v1beta1/txs?query=x", "", "high", "", admission.High},
+		"invalid header":    {rest, http.MethodGet, "/cosmos/tx/v1beta1/txs?query=x", "", "urgent", "", admission.Low},
+		"untrusted header":  {rest, http.MethodGet, "/cosmos/tx/v1beta1/txs?query=x", "", "high", "", admission.Low},
+		"tier":              {rest, http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", "", "", "low", admission.Low},
+		"header above tier": {rest, http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", "", "high", "normal", admission.Normal},
+		"header below tier": {rest, http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", "", "low", "normal", admission.Low},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if name == "untrusted header" {
+				r.RemoteAddr = "203.0.113.1:1234"
+			}
+			if tt.header != "" {
+				r.Header.Set("X-Priority", tt.header)
+			}
+			req := newRequest(tt.proxy.kind, r, []byte(tt.body))
+			if tt.tier != "" {
+				req.client = &auth.Client{Tier: auth.Tier{Priority: tt.tier}}
+			}
+			require.Equal(t, tt.want, tt.proxy.priority(req))
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
+)
+
+// Session affinity modes.
+const (
+	AffinityCookie = "cookie"
+	AffinityHeader = "header"
+	AffinityIP     = "ip"
+)
+
+// maxSessionKey is the longest session key kept, so clients can't make the
+// proxy hold arbitrarily large keys.
+const maxSessionKey = 128
+
+type affinity struct {
+	server *Server
+	expiry time.Time
+}
+
+// affinityKey identifies the client session according to the configured
+// affinity mode, or returns "" if the request has none. In cookie mode,
+// clients without a session get one, which is only kept once they send it
+// back, so clients ignoring cookies don't each add a session.
+func (p *Proxy) affinityKey(w http.ResponseWriter, r *http.Request) string {
+	var key string
+	switch p.cfg.Affinity {
+	case AffinityCookie:
+		if c, err := r.Cookie(p.cfg.AffinityCookie); err == nil && c.Value != "" {
+			key = c.Value
+		} else {
+			p.newSession(w)
+		}
+	case AffinityHeader:
+		key = r.Header.Get(p.cfg.AffinityHeader)
+	case AffinityIP:
+		key = ratelimit.ClientIP(r, p.trusted)
+	}
+	if len(key) > maxSessionKey {
+		return ""
+	}
+	return key
+}
+
+// newSession sets a new session cookie.
+func (p *Proxy) newSession(w http.ResponseWriter) {
+	bts := make([]byte, 16)
+	if _, err := rand.Read(bts); err != nil {
+		slog.Error("could not create session", "err", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.cfg.AffinityCookie,
+		Value:    hex.EncodeToString(bts),
+		Path:     "/",
+		MaxAge:   int(p.cfg.AffinityTTL.Seconds()),
+		Secure:   p.cfg.AffinityCookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// pick picks the server for the request, keeping clients with a session on
+// the same server while it's healthy and eligible, and migrating them to the
+// next one otherwise.
+func (p *Proxy) pick(req *request, key string, exclude ...*Server) *Server {
+	if key == "" {
+		return p.next(req, exclude...)
+	}
+
+	now := time.Now()
+	p.affinityMu.Lock()
+	prev := p.affinity[key]
+	p.affinityMu.Unlock()
+
+	if srv := prev.server; srv != nil && now.Before(prev.expiry) &&
+		!slices.Contains(exclude, srv) && srv.available() && srv.eligible(req) {
+		srv.affinityHits.Add(1)
+		p.stick(key, srv, now)
+		return srv
+	}
+
+	srv := p.next(req, exclude...)
+	if srv == nil {
+		return nil
+	}
+	if prev.server != nil && prev.server != srv && now.Before(prev.expiry) {
+		slog.Info("migrating session", "from", prev.server.name, "to", srv.name)
+		prev.server.affinityMigrations.Add(1)
+	}
+	p.stick(key, srv, now)
+	return srv
+}
+
+// stick keeps the session on the server, unless there are already too many
+// sessions, if they're limited.
+func (p *Proxy) stick(key string, srv *Server, now time.Time) {
+	p.affinityMu.Lock()
+	defer p.affinityMu.Unlock()
+	if _, ok := p.affinity[key]; !ok && p.cfg.AffinityMaxSessions > 0 && len(p.affinity) >= p.cfg.AffinityMaxSessions {
+		slog.Debug("too many sessions, not keeping a new one", "sessions", len(p.affinity))
+		return
+	}
+	p.affinity[key] = affinity{
+		server: srv,
+		expiry: now.Add(p.cfg.AffinityTTL),
+	}
+}
+
+// expireSessions removes expired sessions.
+func (p *Proxy) expireSessions() {
+	now := time.Now()
+	p.affinityMu.Lock()
+	defer p.affinityMu.Unlock()
+	for key, a := range p.affinity {
+		if now.After(a.expiry) {
+			delete(p.affinity, key)
+		}
+	}
+}
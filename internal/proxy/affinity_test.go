@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffinity(t *testing.T) {
+	var providers []seed.Provider
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("srv%d", i)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+		t.Cleanup(srv.Close)
+		providers = append(providers, seed.Provider{Address: srv.URL, Provider: name})
+	}
+
+	setup := func(t *testing.T, mode string) *httptest.Server {
+		t.Helper()
+		cfg := testConfig()
+		cfg.Affinity = mode
+		cfg.AffinityCookie = "session"
+		cfg.AffinityCookieSecure = true
+		cfg.AffinityHeader = "X-Session-ID"
+		cfg.AffinityTTL = time.Minute
+		cfg.AffinityMaxSessions = 2
+		proxy := New(Rest, nil, cfg)
+		require.NoError(t, proxy.doUpdate(providers))
+		proxySrv := httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+		return proxySrv
+	}
+
+	get := func(t *testing.T, url string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(bts)
+	}
+
+	t.Run("header", func(t *testing.T) {
+		proxySrv := setup(t, AffinityHeader)
+		proxy := proxySrv.Config.Handler.(*Proxy)
+
+		_, first := get(t, proxySrv.URL, http.Header{"X-Session-ID": {"a"}})
+		for i := 0; i < 10; i++ {
+			_, body := get(t, proxySrv.URL, http.Header{"X-Session-ID": {"a"}})
+			require.Equal(t, first, body)
+		}
+
+		// without a session, requests are still balanced.
+		seen := map[string]bool{}
+		for i := 0; i < 6; i++ {
+			_, body := get(t, proxySrv.URL, nil)
+			seen[body] = true
+		}
+		require.Len(t, seen, 3)
+
+		var stuck *Server
+		for _, srv := range proxy.servers {
+			if srv.name == first {
+				stuck = srv
+			}
+		}
+		stuck.eject(time.Minute)
+
+		_, migrated := get(t, proxySrv.URL, http.Header{"X-Session-ID": {"a"}})
+		require.NotEqual(t, first, migrated)
+		for i := 0; i < 10; i++ {
+			_, body := get(t, proxySrv.URL, http.Header{"X-Session-ID": {"a"}})
+			require.Equal(t, migrated, body)
+		}
+
+		for _, st := range proxy.Stats() {
+			switch st.Name {
+			case first:
+				require.EqualValues(t, 10, st.StickyHits)
+				require.EqualValues(t, 1, st.Migrations)
+			case migrated:
+				require.EqualValues(t, 10, st.StickyHits)
+				require.Zero(t, st.Migrations)
+			}
+		}
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		proxySrv := setup(t, AffinityCookie)
+		proxy := proxySrv.Config.Handler.(*Proxy)
+		resp, _ := get(t, proxySrv.URL, nil)
+		cookies := resp.Cookies()
+		require.Len(t, cookies, 1)
+		require.Equal(t, "session", cookies[0].Name)
+		require.True(t, cookies[0].Secure)
+		// sessions are only kept once clients send the cookie back.
+		require.Empty(t, proxy.affinity)
+
+		_, first := get(t, proxySrv.URL, http.Header{"Cookie": {cookies[0].String()}})
+		require.Len(t, proxy.affinity, 1)
+		for i := 0; i < 10; i++ {
+			resp, body := get(t, proxySrv.URL, http.Header{"Cookie": {cookies[0].String()}})
+			require.Equal(t, first, body)
+			require.Empty(t, resp.Cookies())
+		}
+	})
+
+	t.Run("max sessions", func(t *testing.T) {
+		proxySrv := setup(t, AffinityHeader)
+		proxy := proxySrv.Config.Handler.(*Proxy)
+		for _, session := range []string{"a", "b", "c", strings.Repeat("d", maxSessionKey+1)} {
+			get(t, proxySrv.URL, http.Header{"X-Session-ID": {session}})
+		}
+		require.Len(t, proxy.affinity, 2)
+		require.Contains(t, proxy.affinity, "a")
+		require.Contains(t, proxy.affinity, "b")
+	})
+
+	t.Run("unlimited sessions", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Affinity = AffinityHeader
+		cfg.AffinityTTL = time.Minute
+		proxy := New(Rest, nil, cfg)
+		for i := 0; i < 3; i++ {
+			proxy.stick(fmt.Sprint(i), nil, time.Now())
+		}
+		require.Len(t, proxy.affinity, 3)
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		proxySrv := setup(t, AffinityIP)
+		_, first := get(t, proxySrv.URL, nil)
+		for i := 0; i < 10; i++ {
+			_, body := get(t, proxySrv.URL, nil)
+			require.Equal(t, first, body)
+		}
+	})
+}
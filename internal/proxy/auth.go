@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/akash-network/rpc-proxy/internal/auth"
+)
+
+const (
+	// apiKeyHeader and apiKeyParam are where clients set their API key.
+	apiKeyHeader = "X-API-Key"
+	apiKeyParam  = "api_key"
+)
+
+// Error codes of unauthenticated clients.
+const (
+	rpcUnauthorized     = -32001
+	grpcUnauthenticated = 16
+)
+
+// WithAuth authenticates clients with the API keys.
+func WithAuth(keys *auth.Store) Option {
+	return func(p *Proxy) { p.keys = keys }
+}
+
+// apiKey takes the API key out of the request, so it isn't sent to nodes. The
+// rest of the query is left as the client sent it.
+func apiKey(r *http.Request) string {
+	key := r.Header.Get(apiKeyHeader)
+	r.Header.Del(apiKeyHeader)
+	if r.URL.RawQuery == "" {
+		return key
+	}
+	pairs := strings.Split(r.URL.RawQuery, "&")
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		name, value, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(name); err != nil || name != apiKeyParam {
+			kept = append(kept, pair)
+			continue
+		}
+		if key == "" {
+			key, _ = url.QueryUnescape(value)
+		}
+	}
+	r.URL.RawQuery = strings.Join(kept, "&")
+	return key
+}
+
+// authenticate finds the client of the API key, if any, and checks its tier
+// allows the request. Clients without a key are only allowed if keys are not
+// required.
+func (p *Proxy) authenticate(w http.ResponseWriter, req *request, key string) bool {
+	if p.keys == nil {
+		return true
+	}
+	if key == "" {
+		if p.cfg.APIKeysRequired {
+			p.fail(w, req, http.StatusUnauthorized, rpcUnauthorized, grpcUnauthenticated, "api key required")
+			return false
+		}
+		return true
+	}
+	client, ok := p.keys.Lookup(key)
+	if !ok {
+		slog.Warn("invalid api key")
+		p.fail(w, req, http.StatusUnauthorized, rpcUnauthorized, grpcUnauthenticated, "invalid api key")
+		return false
+	}
+	if msg := p.denied(req, client.Tier); msg != "" {
+		slog.Warn("request denied by tier", "client", client.Name, "tier", client.TierName, "reason", msg)
+		p.fail(w, req, http.StatusForbidden, rpcMethodNotFound, grpcPermissionDenied, msg)
+		return false
+	}
+	req.client = &client
+	return true
+}
+
+// denied tells why the tier doesn't allow the request, if it doesn't.
+func (p *Proxy) denied(req *request, tier auth.Tier) string {
+	if len(tier.Kinds) > 0 && !slices.Contains(tier.Kinds, p.kind.String()) {
+		return fmt.Sprintf("%s is not allowed for this api key", p.kind)
+	}
+	switch p.kind {
+	case RPC:
+		if len(tier.Methods) == 0 {
+			return ""
+		}
+		for _, call := range req.calls {
+			if !matchPattern(tier.Methods, call.Method) {
+				return fmt.Sprintf("method %s is not allowed for this api key", call.Method)
+			}
+		}
+	case Rest:
+		if len(tier.Paths) > 0 && !slices.ContainsFunc(tier.Paths, func(prefix string) bool {
+			return strings.HasPrefix(req.URL.Path, prefix)
+		}) {
+			return fmt.Sprintf("path %s is not allowed for this api key", req.URL.Path)
+		}
+	}
+	return ""
+}
+
+// withinQuota counts the request in the client's daily quota, replying with
+// 429 Too Many Requests if it's exceeded.
+func (p *Proxy) withinQuota(w http.ResponseWriter, req *request) bool {
+	if req.client == nil || p.keys.Use(*req.client, max(1, len(req.calls))) {
+		return true
+	}
+	slog.Warn("client exceeded its daily quota", "client", req.client.Name)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds(p.keys.QuotaReset())))
+	p.fail(w, req, http.StatusTooManyRequests, rpcLimitExceeded, grpcResourceExhausted,
+		fmt.Sprintf("daily quota of %d requests exceeded", req.client.Tier.DailyQuota))
+	return false
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akash-network/rpc-proxy/internal/auth"
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth(t *testing.T) {
+	var forwarded *http.Request
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	}))
+	t.Cleanup(node.Close)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"tiers": {
+			"free": {"rate_limit": "2/h", "daily_quota": 3, "methods": ["status", "abci_*"]},
+			"rest": {"kinds": ["rest"]}
+		},
+		"keys": {
+			"secret1": {"name": "alice", "tier": "free"},
+			"secret2": {"name": "bob", "tier": "rest"}
+		}
+	}`), 0o600))
+	keys, err := auth.Load(path)
+	require.NoError(t, err)
+
+	cfg := testConfig()
+	cfg.APIKeysRequired = true
+	proxy := New(RPC, nil, cfg, WithAuth(keys))
+	require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: node.URL, Provider: "node"}}))
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	get := func(t *testing.T, target, key string) (*http.Response, rpcReply) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, proxySrv.URL+target, nil)
+		require.NoError(t, err)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var reply rpcReply
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+		return resp, reply
+	}
+
+	resp, reply := get(t, "/status", "")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, "api key required", reply.Error.Message)
+
+	resp, reply = get(t, "/status", "nope")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, "invalid api key", reply.Error.Message)
+
+	resp, reply = get(t, "/status", "secret2")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Equal(t, "rpc is not allowed for this api key", reply.Error.Message)
+
+	resp, reply = get(t, "/net_info", "secret1")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Equal(t, "method net_info is not allowed for this api key", reply.Error.Message)
+
+	// the method of the path is what nodes run, whatever the body says.
+	post, err := http.NewRequest(http.MethodPost, proxySrv.URL+"/net_info", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"status"}`))
+	require.NoError(t, err)
+	post.Header.Set("X-API-Key", "secret1")
+	resp, err = http.DefaultClient.Do(post)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// the key is never sent to nodes.
+	resp, _ = get(t, "/status", "secret1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, forwarded.Header.Get("X-API-Key"))
+	resp, _ = get(t, "/abci_info?z=%2F&api_key=secret1&x=1", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "z=%2F&x=1", forwarded.URL.RawQuery)
+
+	// tier rate limit.
+	resp, reply = get(t, "/status", "secret1")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "rate limit of 2/h exceeded for default requests", reply.Error.Message)
+	require.Equal(t, []LimitedClient{{Client: "alice", Limited: 1}}, proxy.TopLimited(10))
+
+	usage := keys.Usage()
+	require.Len(t, usage, 1)
+	require.EqualValues(t, 2, usage[0].Today)
+
+	t.Run("quota", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(`{
+			"tiers": {"free": {"daily_quota": 1}},
+			"keys": {"secret1": {"name": "carol", "tier": "free"}}
+		}`), 0o600))
+		keys, err := auth.Load(path)
+		require.NoError(t, err)
+		proxy := New(Rest, nil, cfg, WithAuth(keys))
+		proxySrv := httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+
+		do := func() *http.Response {
+			resp, err := http.Post(proxySrv.URL+"/cosmos/tx/v1beta1/simulate?api_key=secret1", "application/json", strings.NewReader("{}"))
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			return resp
+		}
+		require.NotEqual(t, http.StatusTooManyRequests, do().StatusCode)
+		resp := do()
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		require.NotEmpty(t, resp.Header.Get("Retry-After"))
+		require.EqualValues(t, 1, keys.Usage()[0].OverQuota)
+	})
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// splittable tells whether the request is a batch to split across servers.
+func (p *Proxy) splittable(req *request) bool {
+	return p.kind == RPC && p.cfg.RPCBatchSplit && req.batch && len(req.calls) > 1
+}
+
+// split sends each call of a batch on its own, so they can be served by
+// different servers, and reassembles the replies in the order of the calls.
+// Calls that fail to get a reply are replied with a JSON-RPC error with their
+// id.
+func (p *Proxy) split(req *request, session string) *response {
+	resps := make([]*response, len(req.calls))
+	var wg sync.WaitGroup
+	for i, call := range req.calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sub := *req
+			sub.body = call.raw
+			sub.calls = []rpcCall{call}
+			sub.batch = false
+			sub.height = call.height()
+			sub.needs = rpcCapabilities[call.Method]
+			resps[i] = p.forwardCall(&sub, session)
+		}()
+	}
+	wg.Wait()
+
+	result := &response{
+		status: http.StatusOK,
+		header: http.Header{"Content-Type": {"application/json"}},
+	}
+	replies := make([]json.RawMessage, 0, len(req.calls))
+	for i, resp := range resps {
+		call := req.calls[i]
+		if resp == nil {
+			replies = append(replies, rpcErrorReply(call.ID, errNoServers.code, errNoServers.msg))
+			continue
+		}
+		if result.server == nil {
+			result.server = resp.server
+		}
+		if result.failure == NoFailure {
+			result.failure = resp.failure
+		}
+		result.latency = max(result.latency, resp.latency)
+		result.height = max(result.height, resp.height)
+		body := bytes.TrimSpace(resp.body)
+		switch {
+		case len(body) == 0 && call.ID == nil:
+			// notifications have no reply.
+		case len(body) > 0 && body[0] == '{' && json.Valid(body):
+			replies = append(replies, body)
+		default:
+			replies = append(replies, rpcErrorReply(call.ID, errUpstreamFailure.code, http.StatusText(resp.status)))
+		}
+	}
+	result.body, _ = json.Marshal(replies)
+	return result
+}
+
+// rpcErrorReply creates a JSON-RPC error reply.
+func rpcErrorReply(id json.RawMessage, code int, msg string) json.RawMessage {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	bts, _ := json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Error   rpcError        `json:"error"`
+	}{"2.0", id, rpcError{Code: code, Message: msg}})
+	return bts
+}
+
+// writeRPCError replies with a JSON-RPC error.
+func writeRPCError(w http.ResponseWriter, status int, id json.RawMessage, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(rpcErrorReply(id, code, msg))
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+// echoNode replies to each JSON-RPC call with its id and the node name, or
+// with an error for calls to the failing method.
+func echoNode(tb testing.TB, name string) *httptest.Server {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls, batch, _ := parseRPC(r, mustRead(tb, r.Body))
+		var replies []json.RawMessage
+		for _, call := range calls {
+			if call.Method == "failing" {
+				replies = append(replies, rpcErrorReply(call.ID, rpcInternalError, "Internal error"))
+				continue
+			}
+			bts, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": call.ID, "result": name})
+			replies = append(replies, bts)
+		}
+		if batch {
+			_ = json.NewEncoder(w).Encode(replies)
+			return
+		}
+		_, _ = w.Write(replies[0])
+	}))
+	tb.Cleanup(srv.Close)
+	return srv
+}
+
+func mustRead(tb testing.TB, r io.Reader) []byte {
+	tb.Helper()
+	bts, err := io.ReadAll(r)
+	require.NoError(tb, err)
+	return bts
+}
+
+func TestBatch(t *testing.T) {
+	const body = `[{"jsonrpc":"2.0","id":1,"method":"status"},{"jsonrpc":"2.0","id":"two","method":"tx_search"},{"jsonrpc":"2.0","id":3,"method":"failing"}]`
+
+	for _, split := range []bool{false, true} {
+		t.Run(map[bool]string{false: "whole", true: "split"}[split], func(t *testing.T) {
+			node1, node2 := echoNode(t, "node1"), echoNode(t, "node2")
+			cfg := testConfig()
+			cfg.HealthyErrorRateThreshold = 100
+			cfg.RPCBatchSplit = split
+			cfg.RPCMaxBatchSize = 3
+			proxy := New(RPC, nil, cfg)
+			require.NoError(t, proxy.doUpdate([]seed.Provider{
+				{Address: node1.URL, Provider: "node1"},
+				{Address: node2.URL, Provider: "node2"},
+			}))
+			proxySrv := httptest.NewServer(proxy)
+			t.Cleanup(proxySrv.Close)
+
+			resp, err := http.Post(proxySrv.URL, "application/json", strings.NewReader(body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var replies []struct {
+				ID     json.RawMessage `json:"id"`
+				Result string          `json:"result"`
+				Error  *rpcError       `json:"error"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&replies))
+			require.Len(t, replies, 3)
+			require.JSONEq(t, `1`, string(replies[0].ID))
+			require.JSONEq(t, `"two"`, string(replies[1].ID))
+			require.JSONEq(t, `3`, string(replies[2].ID))
+			require.NotNil(t, replies[2].Error)
+			if split {
+				// consecutive calls are spread over both nodes.
+				require.ElementsMatch(t, []string{"node1", "node2"}, []string{replies[0].Result, replies[1].Result})
+			} else {
+				require.Equal(t, replies[0].Result, replies[1].Result)
+			}
+
+			methods := map[string]MethodStat{}
+			for _, st := range proxy.MethodStats() {
+				methods[st.Method] = st
+			}
+			require.Len(t, methods, 3)
+			require.EqualValues(t, 1, methods["status"].Calls)
+			require.Zero(t, methods["status"].Failures)
+			require.EqualValues(t, 1, methods["failing"].Calls)
+			require.EqualValues(t, 1, methods["failing"].Failures)
+
+			resp, err = http.Post(proxySrv.URL, "application/json", strings.NewReader(`[{"id":1,"method":"status"},{"id":2,"method":"status"},{"id":3,"method":"status"},{"id":4,"method":"status"}]`))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			var reply rpcReply
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+			require.Equal(t, rpcInvalidRequest, reply.Error.Code)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+)
+
+// rpcBroadcastMethods are the JSON-RPC methods broadcasting transactions.
+var rpcBroadcastMethods = map[string]bool{
+	"broadcast_tx_sync":   true,
+	"broadcast_tx_async":  true,
+	"broadcast_tx_commit": true,
+}
+
+// restBroadcastPath is the REST endpoint broadcasting transactions.
+const restBroadcastPath = "/cosmos/tx/v1beta1/txs"
+
+// isBroadcast tells whether the request broadcasts a transaction.
+func isBroadcast(kind ProxyKind, r *http.Request, calls []rpcCall) bool {
+	switch kind {
+	case RPC:
+		for _, call := range calls {
+			if rpcBroadcastMethods[call.Method] {
+				return true
+			}
+		}
+	case Rest:
+		return r.Method == http.MethodPost && r.URL.Path == restBroadcastPath
+	}
+	return false
+}
+
+// newBroadcasters creates the trusted servers transactions are broadcasted
+// to, if any.
+func newBroadcasters(kind ProxyKind, addrs []string, cfg config.Config) []*Server {
+	var result []*Server
+	for _, addr := range addrs {
+		u, err := url.Parse(addr)
+		if err != nil {
+			slog.Error("invalid broadcast server", "addr", addr, "err", err)
+			continue
+		}
+		srv, err := newServer(u.Host, addr, kind, cfg)
+		if err != nil {
+			slog.Error("invalid broadcast server", "addr", addr, "err", err)
+			continue
+		}
+		result = append(result, srv)
+	}
+	return result
+}
+
+// broadcastTargets returns the trusted broadcast servers if configured, or
+// up to BROADCAST_FANOUT servers from the pool otherwise.
+func (p *Proxy) broadcastTargets(req *request) []*Server {
+	if len(p.broadcasters) > 0 {
+		return p.broadcasters
+	}
+	var targets []*Server
+	for len(targets) < max(1, p.cfg.BroadcastFanout) {
+		srv := p.next(req, targets...)
+		if srv == nil {
+			break
+		}
+		targets = append(targets, srv)
+	}
+	return targets
+}
+
+// checkTxCode returns the CheckTx code of a broadcast reply, which is not 0 if
+// the node rejected the transaction, e.g. for insufficient fees.
+func checkTxCode(kind ProxyKind, body []byte) uint32 {
+	type result struct {
+		Code    uint32 `json:"code"`
+		CheckTx struct {
+			Code uint32 `json:"code"`
+		} `json:"check_tx"`
+	}
+	var reply struct {
+		Result     result `json:"result"`
+		TxResponse result `json:"tx_response"`
+	}
+	if json.Unmarshal(body, &reply) != nil {
+		return 0
+	}
+	if kind == Rest {
+		return reply.TxResponse.Code
+	}
+	return max(reply.Result.Code, reply.Result.CheckTx.Code)
+}
+
+// broadcastRank ranks the replies of servers that didn't accept a transaction
+// by how meaningful they are to the client: a rejection by CheckTx, then a
+// client error, then a node failure.
+func broadcastRank(resp *response) int {
+	switch resp.failure {
+	case NoFailure:
+		return 2
+	case ClientError:
+		return 1
+	}
+	return 0
+}
+
+// forwardCall sends a call split out of a batch, fanning it out only if it
+// broadcasts a transaction.
+func (p *Proxy) forwardCall(req *request, session string) *response {
+	req.broadcast = rpcBroadcastMethods[req.calls[0].Method]
+	if req.broadcast {
+		return p.broadcast(req)
+	}
+	return p.forward(req, session)
+}
+
+// broadcast sends a transaction to several servers in parallel, so a single
+// flaky node can't drop it, replying with the first response accepting it. If
+// none does, the most meaningful reply is replied: a rejection (e.g.
+// insufficient fees) over a node failure.
+func (p *Proxy) broadcast(req *request) *response {
+	targets := p.broadcastTargets(req)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	// the transaction should reach every target even if the client doesn't
+	// wait for all of them.
+	detached := *req
+	detached.Request = req.WithContext(context.WithoutCancel(req.Context()))
+
+	results := make(chan *response, len(targets))
+	for _, srv := range targets {
+		go func() { results <- srv.do(&detached) }()
+	}
+
+	chosen := make(chan *response, 1)
+	go func() {
+		var accepted []string
+		var best *response
+		for range targets {
+			resp := <-results
+			if resp.failure == NoFailure && checkTxCode(p.kind, resp.body) == 0 {
+				resp.server.broadcasts.Add(1)
+				accepted = append(accepted, resp.server.name)
+				if len(accepted) == 1 {
+					chosen <- resp
+				}
+				continue
+			}
+			if best == nil || broadcastRank(resp) > broadcastRank(best) {
+				best = resp
+			}
+		}
+		if len(accepted) == 0 {
+			chosen <- best
+		}
+		slog.Info("transaction broadcasted", "accepted", accepted, "targets", len(targets))
+	}()
+	resp := <-chosen
+	p.recordMethods(req, resp)
+	return resp
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+const broadcastBody = `{"jsonrpc":"2.0","id":1,"method":"broadcast_tx_sync","params":{"tx":"AAAA"}}`
+
+func TestBroadcast(t *testing.T) {
+	var received atomic.Int32
+	node := func(t *testing.T, delay time.Duration) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bts, _ := io.ReadAll(r.Body)
+			if string(bts) == broadcastBody {
+				received.Add(1)
+			}
+			time.Sleep(delay)
+			_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{"code":0,"hash":"ABCD"}}`)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	cfg := testConfig()
+	cfg.BroadcastFanout = 3
+
+	post := func(t *testing.T, proxy *Proxy) {
+		t.Helper()
+		proxySrv := httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+		resp, err := http.Post(proxySrv.URL, "application/json", strings.NewReader(broadcastBody))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Contains(t, string(bts), `"hash":"ABCD"`)
+	}
+
+	accepted := func(proxy *Proxy) map[string]int64 {
+		result := map[string]int64{}
+		for _, st := range proxy.Stats() {
+			result[st.Name] = st.Broadcasts
+		}
+		return result
+	}
+
+	t.Run("fanout", func(t *testing.T) {
+		received.Store(0)
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		fast := node(t, 0)
+		slow := node(t, 200*time.Millisecond)
+
+		proxy := New(RPC, nil, cfg)
+		require.NoError(t, proxy.doUpdate([]seed.Provider{
+			{Address: down.URL, Provider: "down"},
+			{Address: fast.URL, Provider: "fast"},
+			{Address: slow.URL, Provider: "slow"},
+		}))
+
+		start := time.Now()
+		post(t, proxy)
+		require.Less(t, time.Since(start), 200*time.Millisecond)
+
+		require.Eventually(t, func() bool {
+			return received.Load() == 2 && accepted(proxy)["slow"] == 1
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, map[string]int64{"down": 0, "fast": 1, "slow": 1}, accepted(proxy))
+	})
+
+	t.Run("trusted", func(t *testing.T) {
+		received.Store(0)
+		pool := node(t, 0)
+		trusted := node(t, 0)
+
+		cfg := cfg
+		cfg.RPCBroadcastServers = []string{trusted.URL}
+		proxy := New(RPC, nil, cfg)
+		require.NoError(t, proxy.doUpdate([]seed.Provider{
+			{Address: pool.URL, Provider: "pool"},
+		}))
+
+		post(t, proxy)
+		require.Eventually(t, func() bool { return received.Load() == 1 }, time.Second, 10*time.Millisecond)
+		require.Equal(t, map[string]int64{"pool": 0, trusted.Listener.Addr().String(): 1}, accepted(proxy))
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{"code":13,"log":"insufficient fees","hash":"ABCD"}}`)
+		}))
+		t.Cleanup(rejecting.Close)
+		slow := node(t, 100*time.Millisecond)
+
+		proxy := New(RPC, nil, cfg)
+		require.NoError(t, proxy.doUpdate([]seed.Provider{
+			{Address: rejecting.URL, Provider: "rejecting"},
+			{Address: slow.URL, Provider: "slow"},
+		}))
+		proxySrv := httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+
+		// a node accepting it wins over a faster one rejecting it.
+		resp, err := http.Post(proxySrv.URL, "application/json", strings.NewReader(broadcastBody))
+		require.NoError(t, err)
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Contains(t, string(bts), `"code":0`)
+		require.Equal(t, map[string]int64{"rejecting": 0, "slow": 1}, accepted(proxy))
+
+		// the rejection is replied if no node accepts it.
+		proxy = New(RPC, nil, cfg)
+		require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: rejecting.URL, Provider: "rejecting"}}))
+		proxySrv = httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+		resp, err = http.Post(proxySrv.URL, "application/json", strings.NewReader(broadcastBody))
+		require.NoError(t, err)
+		bts, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Contains(t, string(bts), "insufficient fees")
+		require.Equal(t, map[string]int64{"rejecting": 0}, accepted(proxy))
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		var calls atomic.Int32
+		received.Store(0)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bts, _ := io.ReadAll(r.Body)
+			if string(bts) == broadcastBody {
+				received.Add(1)
+				_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{"code":0,"hash":"ABCD"}}`)
+				return
+			}
+			calls.Add(1)
+			_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":2,"result":{}}`)
+		})
+		var providers []seed.Provider
+		for _, name := range []string{"a", "b", "c"} {
+			srv := httptest.NewServer(handler)
+			t.Cleanup(srv.Close)
+			providers = append(providers, seed.Provider{Address: srv.URL, Provider: name})
+		}
+		proxy := New(RPC, nil, cfg)
+		require.NoError(t, proxy.doUpdate(providers))
+		proxySrv := httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+
+		resp, err := http.Post(proxySrv.URL, "application/json", strings.NewReader(
+			`[`+broadcastBody+`,{"jsonrpc":"2.0","id":2,"method":"status"}]`))
+		require.NoError(t, err)
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Contains(t, string(bts), `"hash":"ABCD"`)
+		require.Eventually(t, func() bool { return received.Load() == 3 }, time.Second, 10*time.Millisecond)
+		require.EqualValues(t, 1, calls.Load())
+	})
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import "github.com/akash-network/rpc-proxy/internal/ratelimit"
+
+// WithProviderBudgets shares the request budgets of providers with other
+// proxies, so a provider's budget covers all its nodes.
+func WithProviderBudgets(budgets *ratelimit.Budgets) Option {
+	return func(p *Proxy) { p.providerBudgets = budgets }
+}
+
+// overBudget tells whether the server or its provider spent their request
+// budget for the current window.
+func (s *Server) overBudget() bool {
+	return (s.budget != nil && s.budget.Spent()) ||
+		(s.providerBudget != nil && s.providerBudget.Spent())
+}
+
+func (s *Server) spend() {
+	if s.budget != nil {
+		s.budget.Spend()
+	}
+	if s.providerBudget != nil {
+		s.providerBudget.Spend()
+	}
+}
+
+// budgetUsed returns how many requests the budget was used for and its
+// size, 0 if there is no budget.
+func budgetUsed(b *ratelimit.Budget) (int, int) {
+	if b == nil {
+		return 0, 0
+	}
+	return b.Used()
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudgets(t *testing.T) {
+	node := func() *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, `{}`)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	rpc1, rpc2, rest1 := node(), node(), node()
+
+	cfg := testConfig()
+	cfg.UpstreamRequestBudget = ratelimit.Rate{Requests: 3, Per: time.Hour}
+	cfg.ProviderRequestBudget = ratelimit.Rate{Requests: 4, Per: time.Hour}
+	providers := ratelimit.NewBudgets(cfg.ProviderRequestBudget)
+	rpc := New(RPC, nil, cfg, WithProviderBudgets(providers))
+	rest := New(Rest, nil, cfg, WithProviderBudgets(providers))
+	require.NoError(t, rpc.doUpdate([]seed.Provider{
+		{Address: rpc1.URL, Provider: "one"},
+		{Address: rpc2.URL, Provider: "two"},
+	}))
+	require.NoError(t, rest.doUpdate([]seed.Provider{{Address: rest1.URL, Provider: "one"}}))
+
+	get := func(p *Proxy) int {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		return w.Code
+	}
+
+	// provider one spends half its budget on REST.
+	require.Equal(t, http.StatusOK, get(rest))
+	require.Equal(t, http.StatusOK, get(rest))
+
+	// so its RPC node gets 2 requests, and the other node its 3.
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusOK, get(rpc))
+	}
+	require.NotEqual(t, http.StatusOK, get(rpc))
+
+	stats := map[string]ServerStat{}
+	for _, st := range rpc.Stats() {
+		stats[st.Name] = st
+	}
+	require.EqualValues(t, 2, stats["one"].Requests)
+	require.Equal(t, 2, stats["one"].BudgetUsed)
+	require.Equal(t, 3, stats["one"].Budget)
+	require.Equal(t, 4, stats["one"].ProviderBudgetUsed)
+	require.Equal(t, 4, stats["one"].ProviderBudget)
+	require.NotZero(t, stats["one"].Spillovers)
+	require.EqualValues(t, 3, stats["two"].Requests)
+	require.Equal(t, 3, stats["two"].BudgetUsed)
+}
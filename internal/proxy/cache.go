@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/cache"
+)
+
+// immutableMaxAge is the max-age replied for responses that never change.
+const immutableMaxAge = 365 * 24 * time.Hour
+
+// rpcHeightMethods are the JSON-RPC methods whose result at a given height
+// never changes once the height is committed.
+var rpcHeightMethods = map[string]bool{
+	"block":         true,
+	"block_results": true,
+	"commit":        true,
+	"header":        true,
+	"validators":    true,
+	"abci_query":    true,
+}
+
+// rpcHashMethods are the JSON-RPC methods whose result for a given hash never
+// changes once found.
+var rpcHashMethods = map[string]bool{
+	"tx":             true,
+	"block_by_hash":  true,
+	"header_by_hash": true,
+}
+
+// restTxPrefix is the REST endpoint of a tx by hash.
+const restTxPrefix = "/cosmos/tx/v1beta1/txs/"
+
+// cacheable tells whether the response to the request can be cached, and for
+// how long. A zero TTL means the response never changes. Only GET requests are
+// cached, as JSON-RPC POST responses echo the request id.
+func (p *Proxy) cacheable(req *request) (time.Duration, bool) {
+	if p.cache == nil || req.Method != http.MethodGet || len(req.body) > 0 {
+		return 0, false
+	}
+	if p.immutable(req) {
+		return 0, true
+	}
+	if p.cfg.CacheMutableTTL > 0 && req.minHeight == 0 && slices.Contains(p.cfg.CacheMutablePaths, req.URL.Path) {
+		return p.cfg.CacheMutableTTL, true
+	}
+	return 0, false
+}
+
+// immutable tells whether the request is for data that can't change anymore:
+// data at a height below the latest one, or a tx or block by hash.
+func (p *Proxy) immutable(req *request) bool {
+	final := func(height int64) bool {
+		_, latest := p.reached(height)
+		return height > 0 && height < latest
+	}
+	switch p.kind {
+	case RPC:
+		if len(req.calls) != 1 {
+			return false
+		}
+		call := req.calls[0]
+		return (rpcHeightMethods[call.Method] && final(req.height)) ||
+			(rpcHashMethods[call.Method] && req.URL.Query().Get("hash") != "")
+	case Rest:
+		if hash, ok := strings.CutPrefix(req.URL.Path, restTxPrefix); ok && hash != "" && !strings.Contains(hash, "/") {
+			return true
+		}
+		return final(req.height)
+	}
+	return false
+}
+
+// varyHeaders are the request headers responses vary by: REST queries at a
+// given height set it in a header, and bodies are only compressed for clients
+// accepting it.
+var varyHeaders = []string{"x-cosmos-block-height", "Accept-Encoding", "Accept"}
+
+// varyKey identifies the request headers responses vary by.
+func varyKey(req *request) string {
+	values := make([]string, len(varyHeaders))
+	for i, name := range varyHeaders {
+		values[i] = strings.Join(req.Header.Values(name), ",")
+	}
+	return strings.Join(values, "|")
+}
+
+// cacheKey identifies the response to a request.
+func cacheKey(req *request) string {
+	return fmt.Sprintf("%s %s?%s %s", req.Method, req.URL.Path, req.URL.RawQuery, varyKey(req))
+}
+
+// cached replies with the cached response to the request, if any.
+func (p *Proxy) cached(w http.ResponseWriter, key string) bool {
+	entry, ok := p.cache.Get(key)
+	if !ok {
+		p.cacheMisses.Add(1)
+		return false
+	}
+	p.cacheHits.Add(1)
+	resp := &response{status: entry.Status, header: entry.Header.Clone(), body: entry.Body}
+	setCacheHeaders(resp.header, entry.TTL, "HIT")
+	resp.header.Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+	resp.writeTo(w)
+	return true
+}
+
+// store caches successful responses. Responses are inspected even if
+// INSPECT_RESPONSES is disabled, so errors are never cached. Streamed
+// responses aren't cached.
+func (p *Proxy) store(key string, ttl time.Duration, resp *response) {
+	if resp.stream != nil || resp.failure != NoFailure || resp.status != http.StatusOK || inspect(p.kind, resp.body) != NoFailure {
+		return
+	}
+	// cookies are for the client that got the response, not everyone.
+	header := resp.header.Clone()
+	header.Del("Set-Cookie")
+	p.cache.Put(key, cache.Entry{
+		Status: resp.status,
+		Header: header,
+		Body:   resp.body,
+		Stored: time.Now(),
+		TTL:    ttl,
+	})
+	setCacheHeaders(resp.header, ttl, "MISS")
+}
+
+// setCacheHeaders sets the caching headers of a cacheable response. Clients
+// tell how fresh cache hits are from their Age header.
+func setCacheHeaders(h http.Header, ttl time.Duration, status string) {
+	cc := fmt.Sprintf("public, max-age=%d", int(ttl.Seconds()))
+	if ttl == 0 {
+		cc = fmt.Sprintf("public, max-age=%d, immutable", int(immutableMaxAge.Seconds()))
+	}
+	h.Set("Cache-Control", cc)
+	h.Set("X-Cache", status)
+}
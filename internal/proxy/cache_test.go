@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	var hits atomic.Int64
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Set-Cookie", "session="+r.Header.Get("Accept-Encoding"))
+		if r.URL.Query().Get("height") == "50" && r.URL.Path == "/block_results" {
+			_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":-1,"error":{"code":-32603,"message":"Internal error"}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":-1,"result":{}}`)
+	}))
+	t.Cleanup(node.Close)
+
+	cfg := testConfig()
+	cfg.CacheSize = 1 << 20
+	cfg.CacheMutableTTL = time.Hour
+	cfg.CacheMutablePaths = []string{"/status"}
+	proxy := New(RPC, nil, cfg)
+	require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: node.URL, Provider: "node"}}))
+	proxy.servers[0].latestHeight.Store(100)
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	get := func(t *testing.T, path string) *http.Response {
+		t.Helper()
+		resp, err := http.Get(proxySrv.URL + path)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	for _, tt := range []struct {
+		path     string
+		cached   string
+		upstream int64
+	}{
+		{"/block?height=50", "public, max-age=31536000, immutable", 1},
+		{"/tx?hash=0xABCD", "public, max-age=31536000, immutable", 1},
+		{"/status", "public, max-age=3600", 1},
+		// the latest block might still change.
+		{"/block_results?height=100", "", 3},
+		{"/net_info", "", 3},
+		// errors are never cached.
+		{"/block_results?height=50", "", 3},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			hits.Store(0)
+			for i := 0; i < 3; i++ {
+				resp := get(t, tt.path)
+				require.Equal(t, tt.cached, resp.Header.Get("Cache-Control"))
+				if tt.cached != "" {
+					require.Equal(t, map[bool]string{true: "MISS", false: "HIT"}[i == 0], resp.Header.Get("X-Cache"))
+				}
+			}
+			require.Equal(t, tt.upstream, hits.Load())
+		})
+	}
+
+	stats := proxy.ProxyStats()
+	require.EqualValues(t, 6, stats.CacheHits)
+	require.EqualValues(t, 3+3, stats.CacheMisses)
+	require.Equal(t, 3, stats.CacheEntries)
+
+	t.Run("vary", func(t *testing.T) {
+		hits.Store(0)
+		do := func(encoding string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, proxySrv.URL+"/commit?height=10", nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept-Encoding", encoding)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			require.NoError(t, resp.Body.Close())
+			return resp
+		}
+		require.Equal(t, "session=gzip", do("gzip").Header.Get("Set-Cookie"))
+		resp := do("identity")
+		require.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+		require.EqualValues(t, 2, hits.Load())
+
+		// cookies of cached responses aren't replied to other clients.
+		resp = do("gzip")
+		require.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+		require.Empty(t, resp.Header.Get("Set-Cookie"))
+	})
+}
+
+func TestCacheable(t *testing.T) {
+	proxy := New(Rest, nil, config.Config{
+		CacheSize:         1 << 20,
+		CacheMutableTTL:   time.Second,
+		CacheMutablePaths: []string{"/cosmos/base/tendermint/v1beta1/blocks/latest"},
+	})
+	srv, err := newServer("srv", "http://srv.local", Rest, config.Config{})
+	require.NoError(t, err)
+	srv.latestHeight.Store(100)
+	proxy.servers = []*Server{srv}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		header http.Header
+		ttl    time.Duration
+		ok     bool
+	}{
+		{http.MethodGet, "/cosmos/tx/v1beta1/txs/ABCD", nil, 0, true},
+		{http.MethodGet, "/cosmos/tx/v1beta1/txs", nil, 0, false},
+		{http.MethodPost, "/cosmos/tx/v1beta1/txs", nil, 0, false},
+		{http.MethodGet, "/cosmos/base/tendermint/v1beta1/blocks/99", nil, 0, true},
+		{http.MethodGet, "/cosmos/base/tendermint/v1beta1/blocks/100", nil, 0, false},
+		{http.MethodGet, "/cosmos/base/tendermint/v1beta1/validatorsets/10", nil, 0, true},
+		{http.MethodGet, "/cosmos/base/tendermint/v1beta1/blocks/latest", nil, time.Second, true},
+		{http.MethodGet, "/cosmos/base/tendermint/v1beta1/blocks/latest", http.Header{"X-Min-Height": {"100"}}, 0, false},
+		{http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", http.Header{"X-Cosmos-Block-Height": {"10"}}, 0, true},
+		{http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", nil, 0, false},
+	} {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			ttl, ok := proxy.cacheable(newRequest(Rest, r, nil))
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.ttl, ttl)
+		})
+	}
+}
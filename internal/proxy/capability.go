@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Capability is a feature a node might have disabled, like tx indexing or some
+// REST modules. Archive nodes are handled by their retained heights instead.
+type Capability uint32
+
+const (
+	TxIndex Capability = 1 << iota
+	AuthModule
+	BankModule
+	StakingModule
+	DistributionModule
+	GovModule
+	MintModule
+	SlashingModule
+	GovV1Module
+)
+
+var capabilityNames = []struct {
+	cap  Capability
+	name string
+}{
+	{TxIndex, "tx_index"},
+	{AuthModule, "auth"},
+	{BankModule, "bank"},
+	{StakingModule, "staking"},
+	{DistributionModule, "distribution"},
+	{GovModule, "gov"},
+	{MintModule, "mint"},
+	{SlashingModule, "slashing"},
+	{GovV1Module, "gov_v1"},
+}
+
+// Names returns the names of the capabilities.
+func (c Capability) Names() []string {
+	var names []string
+	for _, n := range capabilityNames {
+		if c&n.cap != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (c Capability) String() string { return strings.Join(c.Names(), ",") }
+
+// rpcCapabilities maps JSON-RPC methods to the capabilities needed to serve
+// them.
+var rpcCapabilities = map[string]Capability{
+	"tx":           TxIndex,
+	"tx_search":    TxIndex,
+	"block_search": TxIndex,
+}
+
+// restCapabilities maps REST path prefixes (and optionally methods) to the
+// capabilities needed to serve them, and to the cheap endpoint under the same
+// prefix probing them, so a module version is only judged by its own probe.
+// The first matching prefix applies. The tx probe looks up a tx that doesn't
+// exist, so a not found reply means the node has tx indexing enabled, while
+// txs by block are read from the block store.
+var restCapabilities = []struct {
+	method     string
+	prefix     string
+	needs      Capability
+	probe      string
+	notFoundOK bool
+}{
+	{http.MethodGet, "/cosmos/tx/v1beta1/txs/block/", 0, "", false},
+	{http.MethodGet, "/cosmos/tx/v1beta1/txs", TxIndex, "/cosmos/tx/v1beta1/txs/" + strings.Repeat("0", 64), true},
+	{"", "/cosmos/auth/v1beta1/", AuthModule, "/cosmos/auth/v1beta1/params", false},
+	{"", "/cosmos/bank/v1beta1/", BankModule, "/cosmos/bank/v1beta1/params", false},
+	{"", "/cosmos/staking/v1beta1/", StakingModule, "/cosmos/staking/v1beta1/params", false},
+	{"", "/cosmos/distribution/v1beta1/", DistributionModule, "/cosmos/distribution/v1beta1/params", false},
+	{"", "/cosmos/gov/v1beta1/", GovModule, "/cosmos/gov/v1beta1/params/voting", false},
+	{"", "/cosmos/gov/v1/", GovV1Module, "/cosmos/gov/v1/params/voting", false},
+	{"", "/cosmos/mint/v1beta1/", MintModule, "/cosmos/mint/v1beta1/params", false},
+	{"", "/cosmos/slashing/v1beta1/", SlashingModule, "/cosmos/slashing/v1beta1/params", false},
+}
+
+func restCapability(r *http.Request) Capability {
+	for _, route := range restCapabilities {
+		if (route.method == "" || route.method == r.Method) && strings.HasPrefix(r.URL.Path, route.prefix) {
+			return route.needs
+		}
+	}
+	return 0
+}
+
+// supports tells whether the server has all the given capabilities, assuming
+// it does for the ones not probed yet.
+func (s *Server) supports(c Capability) bool {
+	s.capsMu.Lock()
+	defer s.capsMu.Unlock()
+	return (c&s.knownCaps)&^s.caps == 0
+}
+
+func (s *Server) setCapability(c Capability, ok bool) {
+	s.capsMu.Lock()
+	defer s.capsMu.Unlock()
+	s.knownCaps |= c
+	if ok {
+		s.caps |= c
+	} else {
+		s.caps &^= c
+	}
+}
+
+// Capabilities returns the known supported and unsupported capabilities.
+func (s *Server) Capabilities() (supported, unsupported Capability) {
+	s.capsMu.Lock()
+	defer s.capsMu.Unlock()
+	return s.caps, s.knownCaps &^ s.caps
+}
+
+// probeCapabilities probes REST servers for their capabilities, at most once
+// every CAPABILITY_PROBE_INTERVAL. RPC servers report theirs in /status.
+func (s *Server) probeCapabilities(ctx context.Context) {
+	if s.kind != Rest || time.Since(time.Unix(0, s.capsProbedAt.Load())) < s.cfg.CapabilityProbeInterval {
+		return
+	}
+	s.capsProbedAt.Store(time.Now().UnixNano())
+	for _, route := range restCapabilities {
+		if route.probe == "" {
+			continue
+		}
+		ok, err := s.probeCapability(ctx, route.probe, route.notFoundOK)
+		if err != nil {
+			slog.Warn("could not probe capability", "name", s.name, "capability", route.needs, "err", err)
+			continue
+		}
+		s.setCapability(route.needs, ok)
+	}
+}
+
+func (s *Server) probeCapability(ctx context.Context, path string, notFoundOK bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ProxyRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url.JoinPath(path).String(), nil)
+	if err != nil {
+		return false, fmt.Errorf("could not create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("could not get %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	switch {
+	case inspect(s.kind, bts) == NotIndexed:
+		return false, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return notFoundOK, nil
+	case resp.StatusCode == http.StatusNotImplemented:
+		return false, nil
+	case resp.StatusCode == http.StatusOK:
+		return true, nil
+	}
+	return false, fmt.Errorf("could not get %s: %s", path, resp.Status)
+}
+
+// capable tells whether any server might have the given capabilities.
+func (p *Proxy) capable(c Capability) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.servers) == 0 {
+		return true
+	}
+	for _, srv := range p.servers {
+		if srv.supports(c) {
+			return true
+		}
+	}
+	return false
+}
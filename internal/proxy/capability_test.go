@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilityRouting(t *testing.T) {
+	full := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/cosmos/tx/v1beta1/txs/00") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"code":5,"message":"tx not found","details":[]}`)
+			return
+		}
+		_, _ = io.WriteString(w, "full")
+	}))
+	t.Cleanup(full.Close)
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/cosmos/tx/v1beta1/txs/00"):
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `{"code":2,"message":"transaction indexing is disabled","details":[]}`)
+		case strings.HasPrefix(r.URL.Path, "/cosmos/gov/v1beta1/"):
+			w.WriteHeader(http.StatusNotImplemented)
+			_, _ = io.WriteString(w, `{"code":12,"message":"Not Implemented","details":[]}`)
+		default:
+			_, _ = io.WriteString(w, "limited")
+		}
+	}))
+	t.Cleanup(limited.Close)
+
+	cfg := testConfig()
+	cfg.CapabilityProbeInterval = time.Hour
+	proxy := New(Rest, nil, cfg)
+	require.NoError(t, proxy.doUpdate([]seed.Provider{
+		{Address: full.URL, Provider: "full"},
+		{Address: limited.URL, Provider: "limited"},
+	}))
+	proxy.probe(context.Background())
+
+	for _, st := range proxy.Stats() {
+		if st.Name == "limited" {
+			require.Equal(t, []string{"tx_index", "gov"}, st.Unsupported)
+		} else {
+			require.Empty(t, st.Unsupported)
+		}
+	}
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	do := func(t *testing.T, method, path string) string {
+		t.Helper()
+		req, err := http.NewRequest(method, proxySrv.URL+path, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(bts)
+	}
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, "full", do(t, http.MethodGet, "/cosmos/gov/v1beta1/proposals"))
+		require.Equal(t, "full", do(t, http.MethodGet, "/cosmos/tx/v1beta1/txs?events=tx.height=1"))
+	}
+
+	for _, path := range []string{
+		"/cosmos/bank/v1beta1/balances/akash1",
+		// gov v1 is probed on its own.
+		"/cosmos/gov/v1/proposals",
+		// txs by block don't need tx indexing.
+		"/cosmos/tx/v1beta1/txs/block/1",
+	} {
+		seen := map[string]bool{}
+		for i := 0; i < 10; i++ {
+			seen[do(t, http.MethodGet, path)] = true
+		}
+		require.Equal(t, map[string]bool{"full": true, "limited": true}, seen, path)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		seen[do(t, http.MethodPost, "/cosmos/tx/v1beta1/txs")] = true
+	}
+	require.Equal(t, map[string]bool{"full": true, "limited": true}, seen)
+}
+
+func TestRPCCapabilities(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":-1,"result":{"node_info":{"other":{"tx_index":"off"}},"sync_info":{}}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	server, err := newServer("srv", srv.URL, RPC, config.Config{ProxyRequestTimeout: time.Second})
+	require.NoError(t, err)
+	require.True(t, server.supports(TxIndex))
+	require.NoError(t, server.probe(context.Background()))
+	require.False(t, server.supports(TxIndex))
+
+	r := httptest.NewRequest(http.MethodGet, "/tx_search?query=%22tx.height=1%22", nil)
+	require.False(t, server.eligible(newRequest(RPC, r, nil)))
+	r = httptest.NewRequest(http.MethodGet, "/status", nil)
+	require.True(t, server.eligible(newRequest(RPC, r, nil)))
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// coalescible tells whether identical concurrent requests can share a single
+// upstream call: idempotent requests to endpoints in COALESCE_PATHS. JSON-RPC
+// calls sent in a POST body are matched by method.
+func (p *Proxy) coalescible(req *request) bool {
+	if req.broadcast {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return slices.Contains(p.cfg.CoalescePaths, req.URL.Path)
+	case http.MethodPost:
+		return p.kind == RPC && !req.batch && len(req.calls) == 1 &&
+			slices.Contains(p.cfg.CoalescePaths, "/"+req.calls[0].Method)
+	}
+	return false
+}
+
+// coalesceKey identifies identical requests, including what they're routed by.
+func coalesceKey(req *request) string {
+	return fmt.Sprintf("%s %s?%s %s %d %x",
+		req.Method, req.URL.Path, req.URL.RawQuery,
+		varyKey(req), req.minHeight, sha256.Sum256(req.body))
+}
+
+// coalesce makes identical concurrent requests share a single call to fn. The
+// shared call isn't canceled if the client that started it goes away, as
+// others are waiting for it, and each waiter gets its own copy of the
+// response.
+func (p *Proxy) coalesce(req *request, fn func(*request) *response) *response {
+	if !p.coalescible(req) {
+		return fn(req)
+	}
+	var leader bool
+	v, _, shared := p.inflight.Do(coalesceKey(req), func() (any, error) {
+		leader = true
+		detached := *req
+		detached.Request = req.WithContext(context.WithoutCancel(req.Context()))
+		return fn(&detached), nil
+	})
+	if !leader {
+		p.coalesced.Add(1)
+	}
+	resp, _ := v.(*response)
+	if resp != nil && shared {
+		resp = resp.clone()
+	}
+	return resp
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesce(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":-1,"result":{}}`)
+	}))
+	t.Cleanup(node.Close)
+	t.Cleanup(unblock)
+
+	cfg := testConfig()
+	cfg.ProxyRequestTimeout = 5 * time.Second
+	cfg.CoalescePaths = []string{"/status"}
+	proxy := New(RPC, nil, cfg)
+	require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: node.URL, Provider: "node"}}))
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	const clients = 10
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp *http.Response
+			var err error
+			if i%2 == 0 {
+				resp, err = http.Get(proxySrv.URL + "/status")
+			} else {
+				resp, err = http.Post(proxySrv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"status"}`))
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		}()
+	}
+
+	// wait for all clients to be waiting on either upstream call.
+	require.Eventually(t, func() bool { return hits.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	unblock()
+	wg.Wait()
+	require.EqualValues(t, 2, hits.Load())
+	require.EqualValues(t, clients-2, proxy.ProxyStats().Coalesced)
+
+	// other endpoints are not coalesced.
+	resp, err := http.Get(proxySrv.URL + "/net_info")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.EqualValues(t, 3, hits.Load())
+	require.EqualValues(t, clients-2, proxy.ProxyStats().Coalesced)
+}
+
+func TestCoalesceKey(t *testing.T) {
+	key := func(encoding string) string {
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		return coalesceKey(newRequest(RPC, r, nil))
+	}
+	require.Equal(t, key("gzip"), key("gzip"))
+	// compressed responses aren't shared with clients that can't read them.
+	require.NotEqual(t, key("gzip"), key(""))
+}
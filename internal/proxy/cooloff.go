@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// unixThreshold tells apart X-RateLimit-Reset values that are unix times from
+// the ones that are a number of seconds.
+const unixThreshold = 1_000_000_000
+
+// CoolingOff returns how long the server is still cooling off for after
+// rate limiting the proxy, 0 if it isn't.
+func (s *Server) CoolingOff() time.Duration {
+	return max(0, time.Until(time.Unix(0, s.coolingUntil.Load())))
+}
+
+func (s *Server) coolOff(d time.Duration) {
+	s.coolingUntil.Store(time.Now().Add(d).UnixNano())
+}
+
+// backoff tells how long the server asked not to get requests for, when it
+// replied 429, or 503 with a Retry-After or rate limit header. A 429 without
+// any header cools it off for COOLDOWN_DEFAULT, and no server is cooled off
+// for longer than COOLDOWN_MAX.
+func (s *Server) backoff(status int, header http.Header, now time.Time) (time.Duration, bool) {
+	if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	d, ok := retryAfter(header, now)
+	if !ok {
+		if status != http.StatusTooManyRequests {
+			return 0, false
+		}
+		d = s.cfg.CooldownDefault
+	}
+	return min(max(0, d), s.cfg.CooldownMax), true
+}
+
+// retryAfter reads when to retry from the Retry-After header, either in
+// seconds or as a date, or from the RateLimit-Reset and X-RateLimit-Reset
+// headers, in seconds or as a unix time.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if v := header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t.Sub(now), true
+		}
+	}
+	for _, name := range []string{"RateLimit-Reset", "X-RateLimit-Reset"} {
+		secs, err := strconv.ParseInt(header.Get(name), 10, 64)
+		if err != nil {
+			continue
+		}
+		if secs > unixThreshold {
+			return time.Unix(secs, 0).Sub(now), true
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	return 0, false
+}
+
+// throttled cools the server off if the response tells it is rate limiting
+// the proxy, so requests go to other servers meanwhile.
+func (s *Server) throttled(result *response) bool {
+	d, ok := s.backoff(result.status, result.header, time.Now())
+	if !ok {
+		return false
+	}
+	slog.Warn("node is rate limiting, cooling off", "name", s.name, "status", result.status, "for", d)
+	s.coolOff(d)
+	return true
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv := &Server{cfg: config.Config{CooldownDefault: 10 * time.Second, CooldownMax: time.Minute}}
+
+	for name, tt := range map[string]struct {
+		status int
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		"ok":                   {http.StatusOK, http.Header{"Retry-After": {"5"}}, 0, false},
+		"429":                  {http.StatusTooManyRequests, http.Header{}, 10 * time.Second, true},
+		"429 retry after":      {http.StatusTooManyRequests, http.Header{"Retry-After": {"5"}}, 5 * time.Second, true},
+		"429 retry after date": {http.StatusTooManyRequests, http.Header{"Retry-After": {now.Add(30 * time.Second).Format(http.TimeFormat)}}, 30 * time.Second, true},
+		"429 too long":         {http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}}, time.Minute, true},
+		"429 ratelimit reset":  {http.StatusTooManyRequests, http.Header{"Ratelimit-Reset": {"7"}}, 7 * time.Second, true},
+		"429 x-ratelimit unix": {http.StatusTooManyRequests, http.Header{"X-Ratelimit-Reset": {"1704067220"}}, 20 * time.Second, true},
+		"503":                  {http.StatusServiceUnavailable, http.Header{}, 0, false},
+		"503 retry after":      {http.StatusServiceUnavailable, http.Header{"Retry-After": {"2"}}, 2 * time.Second, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, ok := srv.backoff(tt.status, tt.header, now)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, d)
+		})
+	}
+}
+
+func TestCoolOff(t *testing.T) {
+	var limitedHits atomic.Int64
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitedHits.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(limited.Close)
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	}))
+	t.Cleanup(node.Close)
+
+	cfg := testConfig()
+	cfg.RetryAttempts = 1
+	cfg.CooldownDefault = 10 * time.Second
+	cfg.CooldownMax = 5 * time.Minute
+	proxy := New(RPC, nil, cfg)
+	require.NoError(t, proxy.doUpdate([]seed.Provider{
+		{Address: limited.URL, Provider: "limited"},
+		{Address: node.URL, Provider: "node"},
+	}))
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	for i := 0; i < 10; i++ {
+		resp, err := http.Post(proxySrv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"status"}`))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	require.EqualValues(t, 1, limitedHits.Load())
+
+	for _, st := range proxy.Stats() {
+		if st.Name == "limited" {
+			require.Equal(t, time.Minute, st.CoolingOff)
+		} else {
+			require.Zero(t, st.CoolingOff)
+		}
+	}
+}
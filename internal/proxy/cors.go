@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+)
+
+// corsExposedHeaders are the response headers browsers can read.
+var corsExposedHeaders = strings.Join([]string{
+	blockHeightHeader,
+	"X-Cache",
+	"Age",
+	"Retry-After",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+}, ", ")
+
+type corsPolicy struct {
+	origins []string
+	methods []string
+	headers []string
+}
+
+// newCORSPolicy returns the CORS policy of a kind.
+func newCORSPolicy(kind ProxyKind, cfg config.Config) corsPolicy {
+	if kind == Rest {
+		return corsPolicy{cfg.RestCORSOrigins, cfg.RestCORSMethods, cfg.RestCORSHeaders}
+	}
+	return corsPolicy{cfg.RPCCORSOrigins, cfg.RPCCORSMethods, cfg.RPCCORSHeaders}
+}
+
+// cors applies the CORS policy of the proxy kind, so it doesn't depend on the
+// server picked, answering preflight requests itself. It tells whether the
+// request was a preflight request.
+func (p *Proxy) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+	h := w.Header()
+	policy := p.corsPolicy
+	switch {
+	case slices.Contains(policy.origins, "*"):
+		h.Set("Access-Control-Allow-Origin", "*")
+	case slices.Contains(policy.origins, origin):
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	default:
+		// browsers block responses without CORS headers.
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return preflight
+	}
+
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(policy.headers, ", "))
+	if age := p.cfg.CORSMaxAge; age > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(age.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORS(t *testing.T) {
+	var preflights int
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			preflights++
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "https://random.example")
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		_, _ = io.WriteString(w, `{}`)
+	}))
+	t.Cleanup(node.Close)
+
+	cfg := testConfig()
+	cfg.RestCORSOrigins = []string{"https://app.example"}
+	cfg.RestCORSMethods = []string{"GET", "POST"}
+	cfg.RestCORSHeaders = []string{"Content-Type", "X-API-Key"}
+	cfg.RPCCORSOrigins = []string{"*"}
+	cfg.CORSMaxAge = time.Minute
+	do := func(t *testing.T, kind ProxyKind, method, origin string, preflight bool) *http.Response {
+		t.Helper()
+		proxy := New(kind, nil, cfg)
+		require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: node.URL, Provider: "node"}}))
+		proxySrv := httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+
+		req, err := http.NewRequest(method, proxySrv.URL+"/cosmos/bank/v1beta1/params", nil)
+		require.NoError(t, err)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		resp := do(t, Rest, http.MethodOptions, "https://app.example", true)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		require.Equal(t, "https://app.example", resp.Header.Get("Access-Control-Allow-Origin"))
+		require.Equal(t, "GET, POST", resp.Header.Get("Access-Control-Allow-Methods"))
+		require.Equal(t, "Content-Type, X-API-Key", resp.Header.Get("Access-Control-Allow-Headers"))
+		require.Equal(t, "60", resp.Header.Get("Access-Control-Max-Age"))
+		require.Equal(t, "Origin", resp.Header.Get("Vary"))
+		require.Zero(t, preflights)
+	})
+
+	t.Run("preflight from other origin", func(t *testing.T) {
+		resp := do(t, Rest, http.MethodOptions, "https://evil.example", true)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		require.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+		require.Zero(t, preflights)
+	})
+
+	t.Run("request", func(t *testing.T) {
+		resp := do(t, Rest, http.MethodGet, "https://app.example", false)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "https://app.example", resp.Header.Get("Access-Control-Allow-Origin"))
+		require.Contains(t, resp.Header.Get("Access-Control-Expose-Headers"), "X-Block-Height")
+		require.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("upstream headers are stripped", func(t *testing.T) {
+		resp := do(t, Rest, http.MethodGet, "", false)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+		require.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		resp := do(t, RPC, http.MethodGet, "https://other.example", false)
+		require.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+		require.Empty(t, resp.Header.Get("Vary"))
+	})
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// errorDomain is the domain of the ErrorInfo detail of REST errors of the
+// proxy.
+const errorDomain = "akash-rpc-proxy"
+
+// proxyError is a failure of the proxy itself, rather than an error replied by
+// a node, with a code of its own so clients can tell them apart.
+type proxyError struct {
+	status int
+	// code is the JSON-RPC error code for RPC, grpcCode the gRPC status code
+	// and reason the ErrorInfo reason for REST.
+	code     int
+	grpcCode int
+	reason   string
+	msg      string
+}
+
+// JSON-RPC error codes are in the range reserved for server errors.
+var (
+	errNoServers       = proxyError{http.StatusServiceUnavailable, -32010, grpcUnavailable, "NO_SERVERS", "no servers available"}
+	errUpstreamTimeout = proxyError{http.StatusGatewayTimeout, -32011, grpcDeadlineExceeded, "UPSTREAM_TIMEOUT", "node did not reply in time"}
+	errUpstreamFailure = proxyError{http.StatusBadGateway, -32012, grpcUnavailable, "UPSTREAM_FAILURE", "could not proxy request to node"}
+	errShuttingDown    = proxyError{http.StatusServiceUnavailable, -32013, grpcUnavailable, "SHUTTING_DOWN", "proxy is shutting down"}
+	errTooLargeReply   = proxyError{http.StatusBadGateway, -32014, grpcResourceExhausted, "RESPONSE_TOO_LARGE", "response of node is too large"}
+)
+
+// upstreamError is the error replied when a node couldn't be reached.
+func upstreamError(f Failure) proxyError {
+	switch f {
+	case Timeout:
+		return errUpstreamTimeout
+	case TooLarge:
+		return errTooLargeReply
+	}
+	return errUpstreamFailure
+}
+
+// errorInfo is a google.rpc.ErrorInfo detail, as gRPC-gateway replies them.
+type errorInfo struct {
+	Type   string `json:"@type"`
+	Reason string `json:"reason"`
+	Domain string `json:"domain"`
+}
+
+// response is the error reply to a request of the kind, echoing the id of
+// the JSON-RPC call that failed, if any.
+func (e proxyError) response(kind ProxyKind, id json.RawMessage) *response {
+	resp := &response{
+		status: e.status,
+		header: http.Header{"Content-Type": {"application/json"}},
+	}
+	switch kind {
+	case RPC:
+		resp.body = rpcErrorReply(id, e.code, e.msg)
+	case Rest:
+		resp.body = restError(e.grpcCode, e.msg, errorInfo{
+			Type:   "type.googleapis.com/google.rpc.ErrorInfo",
+			Reason: e.reason,
+			Domain: errorDomain,
+		})
+	}
+	return resp
+}
+
+// callID is the id of the JSON-RPC call of the request, nil for batches and
+// REST requests.
+func callID(req *request) json.RawMessage {
+	if req == nil || len(req.calls) != 1 {
+		return nil
+	}
+	return req.calls[0].ID
+}
+
+// failWith replies with an error of the proxy itself.
+func (p *Proxy) failWith(w http.ResponseWriter, req *request, e proxyError) {
+	slog.Error("request failed", "reason", e.reason)
+	e.response(p.kind, callID(req)).writeTo(w)
+}
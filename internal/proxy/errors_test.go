@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cfg := testConfig()
+	cfg.ProxyRequestTimeout = 50 * time.Millisecond
+
+	rpcErr := func(t *testing.T, w *httptest.ResponseRecorder) rpcReply {
+		t.Helper()
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var reply rpcReply
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		require.NotNil(t, reply.Error)
+		return reply
+	}
+	restErr := func(t *testing.T, w *httptest.ResponseRecorder) (int, string) {
+		t.Helper()
+		var reply struct {
+			Code    int `json:"code"`
+			Details []errorInfo
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		require.Len(t, reply.Details, 1)
+		require.Equal(t, errorDomain, reply.Details[0].Domain)
+		return reply.Code, reply.Details[0].Reason
+	}
+
+	t.Run("no servers", func(t *testing.T) {
+		proxy := New(RPC, nil, cfg)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":"abc","method":"status"}`)))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		reply := rpcErr(t, w)
+		require.JSONEq(t, `"abc"`, string(reply.ID))
+		require.Equal(t, errNoServers.code, reply.Error.Code)
+
+		proxy = New(Rest, nil, cfg)
+		w = httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cosmos/base/tendermint/v1beta1/node_info", nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		code, reason := restErr(t, w)
+		require.Equal(t, grpcUnavailable, code)
+		require.Equal(t, "NO_SERVERS", reason)
+	})
+
+	t.Run("upstream failure", func(t *testing.T) {
+		proxy := New(RPC, nil, cfg)
+		require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: down.URL, Provider: "down"}}))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Equal(t, errUpstreamFailure.code, rpcErr(t, w).Error.Code)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		proxy := New(Rest, nil, cfg)
+		require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: slow.URL, Provider: "slow"}}))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cosmos/base/tendermint/v1beta1/node_info", nil))
+		require.Equal(t, http.StatusGatewayTimeout, w.Code)
+		code, reason := restErr(t, w)
+		require.Equal(t, grpcDeadlineExceeded, code)
+		require.Equal(t, "UPSTREAM_TIMEOUT", reason)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(make([]byte, 101))
+		}))
+		t.Cleanup(large.Close)
+		cfg := cfg
+		cfg.MaxResponseSize = 100
+		// responses only the client needs are streamed, whatever their size.
+		for _, inspect := range []bool{false, true} {
+			cfg.InspectResponses = inspect
+			proxy := New(Rest, nil, cfg)
+			require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: large.URL, Provider: "large"}}))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cosmos/base/tendermint/v1beta1/node_info", nil))
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, 101, w.Body.Len())
+		}
+
+		// coalesced responses are shared, so they must be read.
+		cfg.CoalescePaths = []string{"/cosmos/base/tendermint/v1beta1/node_info"}
+		proxy := New(Rest, nil, cfg)
+		require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: large.URL, Provider: "large"}}))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cosmos/base/tendermint/v1beta1/node_info", nil))
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		code, reason := restErr(t, w)
+		require.Equal(t, grpcResourceExhausted, code)
+		require.Equal(t, "RESPONSE_TOO_LARGE", reason)
+		require.Zero(t, proxy.Stats()[0].ErrorRate)
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		proxy := New(RPC, nil, cfg)
+		proxy.shuttingDown.Store(true)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, errShuttingDown.code, rpcErr(t, w).Error.Code)
+	})
+}
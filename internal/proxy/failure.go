@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Failure is the kind of failure a proxied request had.
+type Failure uint8
+
+const (
+	NoFailure Failure = iota
+	ClientError
+	Canceled
+	UpstreamError
+	RateLimited
+	Timeout
+	ConnRefused
+	DNSFailure
+	TLSFailure
+	NetworkError
+	PrunedHeight
+	NotIndexed
+	NodeInternal
+	TooLarge
+
+	failureKinds = iota
+)
+
+var failureNames = [failureKinds]string{
+	NoFailure:     "none",
+	ClientError:   "client_error",
+	Canceled:      "canceled",
+	UpstreamError: "upstream_5xx",
+	RateLimited:   "rate_limited",
+	Timeout:       "timeout",
+	ConnRefused:   "connection_refused",
+	DNSFailure:    "dns",
+	TLSFailure:    "tls",
+	NetworkError:  "network",
+	PrunedHeight:  "pruned_height",
+	NotIndexed:    "not_indexed",
+	NodeInternal:  "node_internal",
+	TooLarge:      "too_large",
+}
+
+func (f Failure) String() string { return failureNames[f] }
+
+// NodeFault tells whether the failure is attributable to the node, and thus
+// should affect its health. Client errors (e.g. querying an account that
+// doesn't exist), requests canceled by the client, requests for data the
+// node doesn't keep and responses too large to proxy don't.
+func (f Failure) NodeFault() bool {
+	switch f {
+	case NoFailure, ClientError, Canceled, PrunedHeight, NotIndexed, TooLarge:
+		return false
+	}
+	return true
+}
+
+// classify tells which kind of failure a proxied request had given the
+// response status code (if any) and error returned by the http client.
+func classify(status int, err error) Failure {
+	if err != nil {
+		return classifyErr(err)
+	}
+	switch {
+	case status == http.StatusTooManyRequests:
+		return RateLimited
+	case status >= 500:
+		return UpstreamError
+	case status >= 400:
+		return ClientError
+	}
+	return NoFailure
+}
+
+func classifyErr(err error) Failure {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+	switch {
+	case errors.Is(err, errTooLarge):
+		return TooLarge
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return Timeout
+	case errors.As(err, &dnsErr):
+		return DNSFailure
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ConnRefused
+	case errors.As(err, &recordErr),
+		errors.As(err, &alertErr),
+		errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &certErr):
+		return TLSFailure
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	}
+	return NetworkError
+}
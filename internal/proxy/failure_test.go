@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	for status, expected := range map[int]Failure{
+		http.StatusOK:                  NoFailure,
+		http.StatusNotModified:         NoFailure,
+		http.StatusNotFound:            ClientError,
+		http.StatusBadRequest:          ClientError,
+		http.StatusTooManyRequests:     RateLimited,
+		http.StatusInternalServerError: UpstreamError,
+		http.StatusServiceUnavailable:  UpstreamError,
+	} {
+		require.Equal(t, expected, classify(status, nil), status)
+	}
+
+	do := func(t *testing.T, ctx context.Context, url string) error {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		require.Error(t, err)
+		return err
+	}
+
+	t.Run("connection refused", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		require.NoError(t, l.Close())
+		require.Equal(t, ConnRefused, classify(-1, do(t, context.Background(), "http://"+addr)))
+	})
+
+	t.Run("dns", func(t *testing.T) {
+		require.Equal(t, DNSFailure, classify(-1, &net.DNSError{Err: "no such host", IsNotFound: true}))
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		srv := httptest.NewTLSServer(http.NotFoundHandler())
+		t.Cleanup(srv.Close)
+		require.Equal(t, TLSFailure, classify(-1, do(t, context.Background(), srv.URL)))
+	})
+
+	t.Run("timeout and canceled", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		t.Cleanup(srv.Close)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		t.Cleanup(cancel)
+		require.Equal(t, Timeout, classify(-1, do(t, ctx, srv.URL)))
+
+		ctx, cancel = context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		require.Equal(t, Canceled, classify(-1, do(t, ctx, srv.URL)))
+	})
+}
+
+func TestClientErrorsKeepServerHealthy(t *testing.T) {
+	srv, err := newServer("srv", "http://srv.local", RPC, testConfig())
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		srv.record(ClientError)
+		srv.record(Canceled)
+	}
+	require.Zero(t, srv.ErrorRate())
+	require.True(t, srv.Healthy())
+
+	srv.record(Timeout)
+	srv.record(RateLimited)
+	require.False(t, srv.Healthy())
+	require.Equal(t, map[string]int64{
+		"client_error": 10,
+		"canceled":     10,
+		"timeout":      1,
+		"rate_limited": 1,
+	}, srv.Failures())
+}
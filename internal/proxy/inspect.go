@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// maxInspectSize is the largest response body inspected for errors. Error
+// replies are small, so there's no need to parse large responses.
+const maxInspectSize = 64 << 10
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcInternalError  = -32603
+)
+
+// gRPC status codes.
+const (
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+)
+
+type rpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type rpcReply struct {
+	ID    json.RawMessage `json:"id,omitempty"`
+	Error *rpcError       `json:"error"`
+}
+
+type grpcStatus struct {
+	Code    *int   `json:"code"`
+	Message string `json:"message"`
+}
+
+// inspect looks for node-side errors hidden in a response body, like
+// CometBFT JSON-RPC errors or gRPC-gateway statuses, which might come with a
+// 200 status code. When it finds one, it's more accurate than the status code.
+func inspect(kind ProxyKind, body []byte) Failure {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || len(body) > maxInspectSize {
+		return NoFailure
+	}
+	switch kind {
+	case RPC:
+		return inspectRPC(body)
+	case Rest:
+		return inspectRest(body)
+	}
+	return NoFailure
+}
+
+func inspectRPC(body []byte) Failure {
+	replies := rpcReplies(body)
+	result := NoFailure
+	for _, reply := range replies {
+		f := reply.failure()
+		if f == NoFailure {
+			continue
+		}
+		// a retriable failure in a batch means the whole batch is retried.
+		if result == NoFailure || f == PrunedHeight || f == NotIndexed {
+			result = f
+		}
+	}
+	return result
+}
+
+// rpcReplies parses the JSON-RPC replies of a response that's small enough
+// to be an error reply.
+func rpcReplies(body []byte) []rpcReply {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || len(body) > maxInspectSize {
+		return nil
+	}
+	if body[0] == '[' {
+		var replies []rpcReply
+		if json.Unmarshal(body, &replies) != nil {
+			return nil
+		}
+		return replies
+	}
+	var reply rpcReply
+	if json.Unmarshal(body, &reply) != nil {
+		return nil
+	}
+	return []rpcReply{reply}
+}
+
+// failure classifies the error of a JSON-RPC reply, if any.
+func (r rpcReply) failure() Failure {
+	if r.Error == nil {
+		return NoFailure
+	}
+	if f := classifyMessage(r.Error.Message + " " + rawString(r.Error.Data)); f != NoFailure {
+		return f
+	}
+	if r.Error.Code == rpcInternalError {
+		return NodeInternal
+	}
+	return ClientError
+}
+
+func inspectRest(body []byte) Failure {
+	var status grpcStatus
+	if body[0] != '{' || json.Unmarshal(body, &status) != nil {
+		return NoFailure
+	}
+	if status.Code == nil || *status.Code == 0 || status.Message == "" {
+		return NoFailure
+	}
+	if f := classifyMessage(status.Message); f != NoFailure {
+		return f
+	}
+	switch *status.Code {
+	case grpcInternal, grpcUnavailable:
+		return NodeInternal
+	case grpcDeadlineExceeded:
+		return Timeout
+	case grpcResourceExhausted:
+		return RateLimited
+	}
+	return ClientError
+}
+
+// classifyMessage recognizes common error messages from nodes that don't
+// have the data requested, or that are caused by the client.
+func classifyMessage(msg string) Failure {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "lowest height is"),
+		strings.Contains(msg, "version does not exist"),
+		strings.Contains(msg, "failed to load state at height"),
+		strings.Contains(msg, "could not find results for height"):
+		return PrunedHeight
+	case strings.Contains(msg, "indexing is disabled"),
+		strings.Contains(msg, "indexer is disabled"):
+		return NotIndexed
+	case strings.Contains(msg, "not found"),
+		strings.Contains(msg, "tx already exists in cache"):
+		return ClientError
+	}
+	return NoFailure
+}
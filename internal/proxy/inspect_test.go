@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	for name, tc := range map[string]struct {
+		kind     ProxyKind
+		body     string
+		expected Failure
+	}{
+		"rpc ok": {
+			RPC, `{"jsonrpc":"2.0","id":1,"result":{}}`, NoFailure,
+		},
+		"rpc pruned": {
+			RPC, `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error","data":"height 123 is not available, lowest height is 4000000"}}`, PrunedHeight,
+		},
+		"rpc not indexed": {
+			RPC, `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error","data":"transaction indexing is disabled"}}`, NotIndexed,
+		},
+		"rpc tx not found": {
+			RPC, `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error","data":"tx (ABCD) not found"}}`, ClientError,
+		},
+		"rpc internal": {
+			RPC, `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error","data":"something broke"}}`, NodeInternal,
+		},
+		"rpc object data": {
+			RPC, `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error","data":{"log":"height 1 is not available, lowest height is 2"}}}`, PrunedHeight,
+		},
+		"rpc invalid params": {
+			RPC, `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"Invalid params"}}`, ClientError,
+		},
+		"rpc batch": {
+			RPC, `[{"jsonrpc":"2.0","id":1,"result":{}},{"jsonrpc":"2.0","id":2,"error":{"code":-32603,"message":"Internal error","data":"height 1 is not available, lowest height is 2"}}]`, PrunedHeight,
+		},
+		"rpc not json": {
+			RPC, `<html></html>`, NoFailure,
+		},
+		"rest ok": {
+			Rest, `{"balances":[],"pagination":{"next_key":null,"total":"0"}}`, NoFailure,
+		},
+		"rest pruned": {
+			Rest, `{"code":2,"message":"rpc error: code = Unknown desc = failed to load state at height 1; version does not exist (latest height: 4000000): unknown request","details":[]}`, PrunedHeight,
+		},
+		"rest not found": {
+			Rest, `{"code":5,"message":"rpc error: code = NotFound desc = account akash1xyz not found: key not found","details":[]}`, ClientError,
+		},
+		"rest internal": {
+			Rest, `{"code":13,"message":"panic","details":[]}`, NodeInternal,
+		},
+		"rest code zero": {
+			Rest, `{"code":0,"message":"ok"}`, NoFailure,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, inspect(tc.kind, []byte(tc.body)))
+		})
+	}
+}
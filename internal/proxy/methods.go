@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/akash-network/rpc-proxy/internal/avg"
+)
+
+// maxMethods is how many distinct JSON-RPC methods stats are kept for, as
+// methods come from clients. Further methods are counted as otherMethod.
+const (
+	maxMethods  = 100
+	otherMethod = "other"
+)
+
+type methodStats struct {
+	calls    atomic.Int64
+	failures atomic.Int64
+	latency  *avg.QuantileWindow
+}
+
+// methodStats returns the stats of a JSON-RPC method.
+func (p *Proxy) methodStats(method string) *methodStats {
+	p.methodsMu.Lock()
+	defer p.methodsMu.Unlock()
+	if _, ok := p.methods[method]; !ok && len(p.methods) >= maxMethods {
+		method = otherMethod
+	}
+	st, ok := p.methods[method]
+	if !ok {
+		st = &methodStats{latency: avg.Quantiles(p.cfg.LatencyWindow)}
+		p.methods[method] = st
+	}
+	return st
+}
+
+// recordMethods attributes the latency and failures of a response to the
+// JSON-RPC methods called. Failures of calls in a batch are told apart by id,
+// unless the response was too large to be read.
+func (p *Proxy) recordMethods(req *request, resp *response) {
+	if p.kind != RPC || resp == nil || len(req.calls) == 0 {
+		return
+	}
+	failures := map[string]Failure{}
+	for _, reply := range rpcReplies(resp.body) {
+		failures[string(reply.ID)] = reply.failure()
+	}
+	for _, call := range req.calls {
+		st := p.methodStats(call.Method)
+		st.calls.Add(1)
+		st.latency.Next(resp.latency)
+		f, ok := failures[string(call.ID)]
+		if !ok {
+			f = resp.failure
+		}
+		if f.NodeFault() {
+			st.failures.Add(1)
+		}
+	}
+}
+
+// MethodStats returns the stats of the JSON-RPC methods called, most called
+// first.
+func (p *Proxy) MethodStats() []MethodStat {
+	p.methodsMu.Lock()
+	defer p.methodsMu.Unlock()
+	result := make([]MethodStat, 0, len(p.methods))
+	for method, st := range p.methods {
+		result = append(result, MethodStat{
+			Method:   method,
+			Calls:    st.calls.Load(),
+			Failures: st.failures.Load(),
+			P50:      st.latency.Quantile(0.5),
+			P90:      st.latency.Quantile(0.9),
+			P99:      st.latency.Quantile(0.99),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Calls != result[j].Calls {
+			return result[i].Calls > result[j].Calls
+		}
+		return result[i].Method < result[j].Method
+	})
+	return result
+}
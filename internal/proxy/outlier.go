@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	// outlierMinPool is the minimum amount of servers with enough requests
+	// needed to tell what is normal for the pool.
+	outlierMinPool = 3
+	// outlierMinStdDev is the minimum error rate standard deviation (in
+	// percentage points) used, so a pool with no errors at all doesn't eject a
+	// server for a single failure.
+	outlierMinStdDev = 5
+)
+
+func (s *Server) Ejected() bool {
+	return time.Now().UnixNano() < s.ejectedUntil.Load()
+}
+
+func (s *Server) eject(base time.Duration) time.Duration {
+	d := base * time.Duration(s.ejections.Add(1))
+	s.ejectedUntil.Store(time.Now().Add(d).UnixNano())
+	return d
+}
+
+// reinstate resets the latencies and results of a server whose ejection
+// ended, so it's only judged by the requests it serves from now on, and warms
+// it up again. It tells whether the server was reinstated.
+func (s *Server) reinstate() bool {
+	until := s.ejectedUntil.Load()
+	if until == 0 || time.Now().UnixNano() < until || !s.ejectedUntil.CompareAndSwap(until, 0) {
+		return false
+	}
+	s.latency.Reset()
+	s.results.Reset()
+	s.startWarmup()
+	s.refreshHealth()
+	return true
+}
+
+// forgive decreases how many times in a row the server was ejected, so a
+// server that behaves again gets shorter ejections over time.
+func (s *Server) forgive() {
+	if s.ejections.Load() > 0 {
+		s.ejections.Add(-1)
+	}
+}
+
+type outlier struct {
+	srv      *Server
+	severity float64
+}
+
+// detectOutliers compares the servers with the rest of the pool and ejects the
+// ones that are much slower or fail much more often than their peers, without
+// ejecting more than the configured share of the pool.
+func (p *Proxy) detectOutliers() {
+	p.mu.Lock()
+	servers := slices.Clone(p.servers)
+	p.mu.Unlock()
+
+	var candidates []*Server
+	var latencies, rates []float64
+	ejected := 0
+	for _, srv := range servers {
+		if srv.Ejected() {
+			ejected++
+			continue
+		}
+		if srv.reinstate() {
+			slog.Info("reinstating ejected server", "name", srv.name)
+			continue
+		}
+		if srv.latency.Count() < uint64(max(1, p.cfg.OutlierMinRequests)) {
+			continue
+		}
+		candidates = append(candidates, srv)
+		latencies = append(latencies, float64(srv.Latency()))
+		rates = append(rates, srv.ErrorRate())
+	}
+	if len(candidates) < outlierMinPool {
+		return
+	}
+
+	median := median(latencies)
+	var outliers []outlier
+	for i, srv := range candidates {
+		var severity float64
+		if limit := median * p.cfg.OutlierLatencyFactor; limit > 0 && latencies[i] > limit {
+			severity = latencies[i] / limit
+		}
+		// compare the error rate with the rest of the pool only, otherwise a
+		// single outlier skews the deviation enough to hide itself.
+		mean, stddev := meanStdDev(slices.Delete(slices.Clone(rates), i, i+1))
+		if limit := mean + max(stddev, outlierMinStdDev)*p.cfg.OutlierErrorRateStdDev; rates[i] > limit {
+			severity = max(severity, rates[i]/limit)
+		}
+		if severity == 0 {
+			srv.forgive()
+			continue
+		}
+		outliers = append(outliers, outlier{srv, severity})
+	}
+	sort.Slice(outliers, func(i, j int) bool { return outliers[i].severity > outliers[j].severity })
+
+	maxEjected := len(servers) * p.cfg.OutlierMaxEjectionPct / 100
+	for _, o := range outliers {
+		if ejected >= maxEjected {
+			slog.Warn("too many servers ejected, keeping outlier", "name", o.srv.name, "ejected", ejected)
+			continue
+		}
+		d := o.srv.eject(p.cfg.OutlierBaseEjectionTime)
+		ejected++
+		slog.Warn("ejecting outlier", "name", o.srv.name, "latency", o.srv.Latency(), "error_rate", o.srv.ErrorRate(), "for", d)
+	}
+}
+
+func median(v []float64) float64 {
+	v = slices.Clone(v)
+	slices.Sort(v)
+	if len(v)%2 == 0 {
+		return (v[len(v)/2-1] + v[len(v)/2]) / 2
+	}
+	return v[len(v)/2]
+}
+
+func meanStdDev(v []float64) (float64, float64) {
+	var sum float64
+	for _, f := range v {
+		sum += f
+	}
+	mean := sum / float64(len(v))
+	var variance float64
+	for _, f := range v {
+		variance += (f - mean) * (f - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(v)))
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectOutliers(t *testing.T) {
+	setup := func(tb testing.TB, n int) *Proxy {
+		tb.Helper()
+		cfg := testConfig()
+		cfg.HealthyThreshold = time.Minute
+		cfg.HealthyErrorRateThreshold = 100
+		cfg.OutlierLatencyFactor = 3
+		cfg.OutlierErrorRateStdDev = 2
+		cfg.OutlierMinRequests = 10
+		cfg.OutlierBaseEjectionTime = time.Minute
+		cfg.OutlierMaxEjectionPct = 20
+		proxy := New(RPC, nil, cfg)
+		var providers []seed.Provider
+		for i := 0; i < n; i++ {
+			providers = append(providers, seed.Provider{
+				Address:  fmt.Sprintf("http://srv%d.local", i),
+				Provider: fmt.Sprintf("srv%d", i),
+			})
+		}
+		require.NoError(tb, proxy.doUpdate(providers))
+		for _, srv := range proxy.servers {
+			for i := 0; i < 10; i++ {
+				srv.latency.Next(10 * time.Millisecond)
+				srv.results.Success()
+			}
+		}
+		return proxy
+	}
+
+	t.Run("healthy pool", func(t *testing.T) {
+		proxy := setup(t, 5)
+		proxy.detectOutliers()
+		for _, srv := range proxy.servers {
+			require.False(t, srv.Ejected(), srv.name)
+		}
+	})
+
+	t.Run("slow", func(t *testing.T) {
+		proxy := setup(t, 5)
+		slow := proxy.servers[2]
+		slow.latency.Reset()
+		for i := 0; i < 10; i++ {
+			slow.latency.Next(100 * time.Millisecond)
+		}
+		require.True(t, slow.Healthy())
+		proxy.detectOutliers()
+		require.True(t, slow.Ejected())
+		require.EqualValues(t, 1, slow.ejections.Load())
+		for i := 0; i < 10; i++ {
+			require.NotEqual(t, slow, proxy.next(nil))
+		}
+	})
+
+	t.Run("failing", func(t *testing.T) {
+		proxy := setup(t, 5)
+		failing := proxy.servers[0]
+		for i := 0; i < 10; i++ {
+			failing.results.Failure()
+		}
+		proxy.detectOutliers()
+		require.True(t, failing.Ejected())
+	})
+
+	t.Run("max ejections", func(t *testing.T) {
+		proxy := setup(t, 5)
+		for _, i := range []int{1, 3} {
+			for j := 0; j < 10; j++ {
+				proxy.servers[i].latency.Next(time.Second)
+			}
+		}
+		proxy.detectOutliers()
+		var ejected int
+		for _, srv := range proxy.servers {
+			if srv.Ejected() {
+				ejected++
+			}
+		}
+		require.Equal(t, 1, ejected)
+	})
+
+	t.Run("reinstated", func(t *testing.T) {
+		proxy := setup(t, 5)
+		srv := proxy.servers[0]
+		for i := 0; i < 10; i++ {
+			srv.latency.Next(time.Second)
+		}
+		proxy.detectOutliers()
+		require.True(t, srv.Ejected())
+
+		// the samples from before the ejection don't count anymore.
+		srv.ejectedUntil.Store(time.Now().Add(-time.Second).UnixNano())
+		proxy.detectOutliers()
+		require.False(t, srv.Ejected())
+		require.Zero(t, srv.latency.Count())
+		require.False(t, srv.WarmedUp())
+
+		for i := 0; i < 10; i++ {
+			srv.latency.Next(10 * time.Millisecond)
+		}
+		proxy.detectOutliers()
+		require.False(t, srv.Ejected())
+		require.Zero(t, srv.ejections.Load())
+	})
+
+	t.Run("growing ejection", func(t *testing.T) {
+		proxy := setup(t, 5)
+		srv := proxy.servers[0]
+		require.Equal(t, time.Minute, srv.eject(time.Minute))
+		require.Equal(t, 2*time.Minute, srv.eject(time.Minute))
+		srv.ejectedUntil.Store(0)
+		proxy.detectOutliers()
+		require.EqualValues(t, 1, srv.ejections.Load())
+	})
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC 2.0 error codes of rejected calls.
+const (
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// rpcPagedMethods are the JSON-RPC methods taking a per_page param.
+var rpcPagedMethods = map[string]bool{
+	"tx_search":       true,
+	"block_search":    true,
+	"validators":      true,
+	"unconfirmed_txs": true,
+}
+
+// rejection is why the policy rejects a request.
+type rejection struct {
+	rule   string
+	status int
+	// code is the JSON-RPC error code for RPC, or the gRPC status code for
+	// REST, and id the id of the JSON-RPC call rejected.
+	code int
+	id   json.RawMessage
+	msg  string
+}
+
+// restPaginationLimit is the query param limiting the page size of REST
+// queries.
+const restPaginationLimit = "pagination.limit"
+
+// checkRest applies the REST policy to the request, clamping its page size if
+// configured to.
+func (p *Proxy) checkRest(req *request) *rejection {
+	if len(p.cfg.RestAllowPaths) > 0 && !slices.ContainsFunc(p.cfg.RestAllowPaths, func(prefix string) bool {
+		return strings.HasPrefix(req.URL.Path, prefix)
+	}) {
+		return &rejection{
+			rule:   "path_denied",
+			status: http.StatusForbidden,
+			code:   grpcPermissionDenied,
+			msg:    fmt.Sprintf("path %s is not allowed", req.URL.Path),
+		}
+	}
+	if len(p.cfg.RestAllowMethods) > 0 && !slices.Contains(p.cfg.RestAllowMethods, req.Method) {
+		return &rejection{
+			rule:   "method_denied",
+			status: http.StatusMethodNotAllowed,
+			code:   grpcPermissionDenied,
+			msg:    fmt.Sprintf("method %s is not allowed", req.Method),
+		}
+	}
+
+	query := req.URL.Query()
+	for _, param := range p.cfg.RestBlockedParams {
+		name, value, hasValue := strings.Cut(param, "=")
+		if blocked(query, name, value, hasValue) {
+			return &rejection{
+				rule:   "blocked_param",
+				status: http.StatusBadRequest,
+				code:   grpcInvalidArgument,
+				msg:    fmt.Sprintf("query param %s is not allowed", param),
+			}
+		}
+	}
+
+	limit := p.cfg.RestMaxPaginationLimit
+	if n, _ := strconv.Atoi(query.Get(restPaginationLimit)); limit > 0 && n > limit {
+		if !p.cfg.RestClampPaginationLimit {
+			return &rejection{
+				rule:   "pagination_limit",
+				status: http.StatusBadRequest,
+				code:   grpcInvalidArgument,
+				msg:    fmt.Sprintf("%s %d exceeds the maximum of %d", restPaginationLimit, n, limit),
+			}
+		}
+		p.hit("pagination_limit_clamped")
+		query.Set(restPaginationLimit, strconv.Itoa(limit))
+		req.URL.RawQuery = query.Encode()
+	}
+	return nil
+}
+
+// blocked tells whether the query has the param, with the value if any, in
+// any of its values. gRPC-gateway accepts both the snake_case and camelCase
+// names of params, e.g. pagination.count_total and pagination.countTotal.
+func blocked(query url.Values, name, value string, hasValue bool) bool {
+	for k, values := range query {
+		if paramName(k) != paramName(name) {
+			continue
+		}
+		if !hasValue || slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, value) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func paramName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// matchPattern tells whether the method matches any of the patterns, which
+// can have wildcards, e.g. unsafe_*.
+func matchPattern(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRPC applies the RPC policy to every call of the request.
+func (p *Proxy) checkRPC(req *request) *rejection {
+	if req.invalid != nil {
+		return req.invalid
+	}
+	for _, call := range req.calls {
+		if (len(p.cfg.RPCAllowMethods) > 0 && !matchPattern(p.cfg.RPCAllowMethods, call.Method)) ||
+			matchPattern(p.cfg.RPCDenyMethods, call.Method) {
+			return &rejection{
+				rule:   "method_denied",
+				status: http.StatusForbidden,
+				code:   rpcMethodNotFound,
+				id:     call.ID,
+				msg:    fmt.Sprintf("method %s is not allowed", call.Method),
+			}
+		}
+		if limit := p.cfg.RPCMaxPerPage; limit > 0 && rpcPagedMethods[call.Method] {
+			if perPage, _ := strconv.Atoi(call.param("per_page")); perPage > limit {
+				return &rejection{
+					rule:   "per_page",
+					status: http.StatusBadRequest,
+					code:   rpcInvalidParams,
+					id:     call.ID,
+					msg:    fmt.Sprintf("per_page %d exceeds the maximum of %d", perPage, limit),
+				}
+			}
+		}
+		if limit := p.cfg.RPCMaxQueryConditions; limit > 0 {
+			if n := queryConditions(call.param("query")); n > limit {
+				return &rejection{
+					rule:   "query_conditions",
+					status: http.StatusBadRequest,
+					code:   rpcInvalidParams,
+					id:     call.ID,
+					msg:    fmt.Sprintf("query has %d conditions, the maximum is %d", n, limit),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// queryConditions counts the conditions of a tx_search or block_search query,
+// e.g. "tx.height>5 AND message.sender='akash1...'".
+func queryConditions(query string) int {
+	if strings.TrimSpace(query) == "" {
+		return 0
+	}
+	return strings.Count(strings.ToUpper(query), " AND ") + 1
+}
+
+// check applies the policy of the proxy kind to the request, which might
+// change it to comply.
+func (p *Proxy) check(req *request) *rejection {
+	var rej *rejection
+	switch p.kind {
+	case RPC:
+		rej = p.checkRPC(req)
+	case Rest:
+		rej = p.checkRest(req)
+	}
+	if rej != nil {
+		p.hit(rej.rule)
+	}
+	return rej
+}
+
+func (p *Proxy) hit(rule string) {
+	p.policyMu.Lock()
+	defer p.policyMu.Unlock()
+	p.policyHits[rule]++
+}
+
+// reject replies with the rejection in the format of the proxy kind.
+func (p *Proxy) reject(w http.ResponseWriter, rej *rejection) {
+	switch p.kind {
+	case RPC:
+		writeRPCError(w, rej.status, rej.id, rej.code, rej.msg)
+	case Rest:
+		writeRestError(w, rej.status, rej.code, rej.msg)
+	}
+}
+
+// fail replies with an error for a request of any kind, with either the
+// JSON-RPC or the gRPC error code.
+func (p *Proxy) fail(w http.ResponseWriter, req *request, status, rpcCode, grpcCode int, msg string) {
+	rej := &rejection{status: status, code: rpcCode, id: callID(req), msg: msg}
+	if p.kind == Rest {
+		rej.code = grpcCode
+	}
+	p.reject(w, rej)
+}
+
+// PolicyHits returns how many requests each policy rule rejected or changed.
+func (p *Proxy) PolicyHits() map[string]int64 {
+	p.policyMu.Lock()
+	defer p.policyMu.Unlock()
+	return maps.Clone(p.policyHits)
+}
+
+// restError creates a gRPC-gateway style error.
+func restError(code int, msg string, details ...any) []byte {
+	if details == nil {
+		details = []any{}
+	}
+	bts, _ := json.Marshal(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Details []any  `json:"details"`
+	}{code, msg, details})
+	return bts
+}
+
+// writeRestError replies with a gRPC-gateway style error.
+func writeRestError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(restError(code, msg))
+}
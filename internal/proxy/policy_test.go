@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRPCPolicy(t *testing.T) {
+	proxy := New(RPC, nil, config.Config{
+		RPCDenyMethods:        []string{"dial_seeds", "dial_peers", "unsafe_*"},
+		RPCMaxPerPage:         50,
+		RPCMaxQueryConditions: 2,
+	})
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		target string
+		body   string
+		rule   string
+		status int
+		code   int
+	}{
+		{"status", http.MethodGet, "/status", "", "", 0, 0},
+		{"denied uri", http.MethodGet, "/dial_seeds?seeds=[]", "", "method_denied", http.StatusForbidden, rpcMethodNotFound},
+		{"denied wildcard", http.MethodPost, "/", `{"jsonrpc":"2.0","id":1,"method":"unsafe_flush_mempool"}`, "method_denied", http.StatusForbidden, rpcMethodNotFound},
+		{"denied in batch", http.MethodPost, "/", `[{"id":1,"method":"status"},{"id":2,"method":"dial_peers"}]`, "method_denied", http.StatusForbidden, rpcMethodNotFound},
+		{"per_page uri", http.MethodGet, `/tx_search?query="tx.height=5"&per_page=100`, "", "per_page", http.StatusBadRequest, rpcInvalidParams},
+		{"per_page body", http.MethodPost, "/", `{"id":1,"method":"tx_search","params":{"query":"tx.height=5","per_page":"100"}}`, "per_page", http.StatusBadRequest, rpcInvalidParams},
+		{"per_page ok", http.MethodPost, "/", `{"id":1,"method":"tx_search","params":{"query":"tx.height=5","per_page":50}}`, "", 0, 0},
+		{"conditions", http.MethodPost, "/", `{"id":1,"method":"tx_search","params":{"query":"tx.height>5 AND tx.height<10 and message.action='send'"}}`, "query_conditions", http.StatusBadRequest, rpcInvalidParams},
+		{"denied path with body", http.MethodPost, "/dial_seeds?seeds=x", `{"jsonrpc":"2.0","id":1,"method":"status"}`, "method_denied", http.StatusForbidden, rpcMethodNotFound},
+		{"denied path with junk", http.MethodPost, "/unsafe_flush_mempool", `junk`, "method_denied", http.StatusForbidden, rpcMethodNotFound},
+		{"parse error", http.MethodPost, "/", `{"method":"unsafe_flush_mempool"`, "invalid_request", http.StatusBadRequest, rpcParseError},
+		{"no method", http.MethodPost, "/", `{"jsonrpc":"2.0","id":1}`, "invalid_request", http.StatusBadRequest, rpcInvalidRequest},
+		{"empty batch", http.MethodPost, "/", `[]`, "invalid_request", http.StatusBadRequest, rpcInvalidRequest},
+		{"conditions ok", http.MethodGet, `/block_search?query="block.height>5%20AND%20block.height<10"`, "", "", 0, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			rej := proxy.check(newRequest(RPC, r, []byte(tt.body)))
+			if tt.rule == "" {
+				require.Nil(t, rej)
+				return
+			}
+			require.NotNil(t, rej)
+			require.Equal(t, tt.rule, rej.rule)
+			require.Equal(t, tt.status, rej.status)
+			require.Equal(t, tt.code, rej.code)
+		})
+	}
+
+	t.Run("reply", func(t *testing.T) {
+		proxySrv := httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+
+		resp, err := http.Post(proxySrv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":"abc","method":"unsafe_flush_mempool"}`))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		var reply rpcReply
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+		require.JSONEq(t, `"abc"`, string(reply.ID))
+		require.Equal(t, rpcMethodNotFound, reply.Error.Code)
+		require.Equal(t, "method unsafe_flush_mempool is not allowed", reply.Error.Message)
+		require.EqualValues(t, 6, proxy.PolicyHits()["method_denied"])
+	})
+}
+
+func TestRPCAllowMethods(t *testing.T) {
+	proxy := New(RPC, nil, config.Config{
+		RPCAllowMethods: []string{"status", "abci_*"},
+	})
+	for method, allowed := range map[string]bool{
+		"status":     true,
+		"abci_query": true,
+		"tx_search":  false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/"+method, nil)
+		require.Equal(t, allowed, proxy.check(newRequest(RPC, r, nil)) == nil, method)
+	}
+}
+
+func TestRestPolicy(t *testing.T) {
+	cfg := config.Config{
+		RestAllowPaths:           []string{"/cosmos/", "/akash/"},
+		RestAllowMethods:         []string{http.MethodGet, http.MethodPost},
+		RestMaxPaginationLimit:   100,
+		RestClampPaginationLimit: true,
+		RestBlockedParams:        []string{"pagination.count_total=true", "pagination.offset"},
+	}
+	proxy := New(Rest, nil, cfg)
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		target string
+		rule   string
+		status int
+		query  string
+	}{
+		{"ok", http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", "", 0, ""},
+		{"path", http.MethodGet, "/ibc/core/client/v1/params", "path_denied", http.StatusForbidden, ""},
+		{"method", http.MethodDelete, "/cosmos/bank/v1beta1/params", "method_denied", http.StatusMethodNotAllowed, ""},
+		{"count total", http.MethodGet, "/cosmos/bank/v1beta1/denoms_metadata?pagination.count_total=true", "blocked_param", http.StatusBadRequest, ""},
+		{"count total false", http.MethodGet, "/cosmos/bank/v1beta1/denoms_metadata?pagination.count_total=false", "", 0, "pagination.count_total=false"},
+		{"offset", http.MethodGet, "/cosmos/bank/v1beta1/denoms_metadata?pagination.offset=10", "blocked_param", http.StatusBadRequest, ""},
+		{"count total repeated", http.MethodGet, "/cosmos/bank/v1beta1/denoms_metadata?pagination.count_total=false&pagination.count_total=true", "blocked_param", http.StatusBadRequest, ""},
+		{"count total camel case", http.MethodGet, "/cosmos/bank/v1beta1/denoms_metadata?pagination.countTotal=TRUE", "blocked_param", http.StatusBadRequest, ""},
+		{"clamped", http.MethodGet, "/cosmos/bank/v1beta1/denoms_metadata?pagination.limit=100000&pagination.key=abc", "", 0, "pagination.key=abc&pagination.limit=100"},
+		{"limit ok", http.MethodGet, "/cosmos/bank/v1beta1/denoms_metadata?pagination.limit=10", "", 0, "pagination.limit=10"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			rej := proxy.check(newRequest(Rest, r, nil))
+			if tt.rule == "" {
+				require.Nil(t, rej)
+				require.Equal(t, tt.query, r.URL.RawQuery)
+				return
+			}
+			require.NotNil(t, rej)
+			require.Equal(t, tt.rule, rej.rule)
+			require.Equal(t, tt.status, rej.status)
+		})
+	}
+	require.Equal(t, map[string]int64{
+		"path_denied":              1,
+		"method_denied":            1,
+		"blocked_param":            4,
+		"pagination_limit_clamped": 1,
+	}, proxy.PolicyHits())
+
+	t.Run("reject", func(t *testing.T) {
+		cfg.RestClampPaginationLimit = false
+		proxy := New(Rest, nil, cfg)
+		proxySrv := httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+
+		resp, err := http.Get(proxySrv.URL + "/cosmos/bank/v1beta1/denoms_metadata?pagination.limit=100000")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var status struct {
+			Code    int      `json:"code"`
+			Message string   `json:"message"`
+			Details []string `json:"details"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		require.Equal(t, grpcInvalidArgument, status.Code)
+		require.Equal(t, "pagination.limit 100000 exceeds the maximum of 100", status.Message)
+		require.Equal(t, map[string]int64{"pagination_limit": 1}, proxy.PolicyHits())
+	})
+}
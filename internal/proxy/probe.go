@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+type rpcStatus struct {
+	NodeInfo struct {
+		Other struct {
+			TxIndex string `json:"tx_index"`
+		} `json:"other"`
+	} `json:"node_info"`
+	SyncInfo struct {
+		EarliestBlockHeight string `json:"earliest_block_height"`
+		LatestBlockHeight   string `json:"latest_block_height"`
+	} `json:"sync_info"`
+}
+
+type restLatestBlock struct {
+	Block struct {
+		Header struct {
+			Height string `json:"height"`
+		} `json:"header"`
+	} `json:"block"`
+}
+
+type restNodeStatus struct {
+	EarliestStoreHeight string `json:"earliest_store_height"`
+}
+
+var lowestHeightRe = regexp.MustCompile(`lowest height is (\d+)`)
+
+// restHeightHeader is the header gRPC-gateway replies with the height a query
+// was served at.
+const restHeightHeader = "Grpc-Metadata-X-Cosmos-Block-Height"
+
+// covers tells whether the server is known to retain the given height. Servers
+// whose earliest height is not known yet are assumed to have it. Heights above
+// the latest one probed are not ruled out, as the server probably reached them
+// since: clients needing it to be fresh set X-Min-Height.
+func (s *Server) covers(height int64) bool {
+	earliest := s.earliestHeight.Load()
+	return earliest == 0 || earliest <= height
+}
+
+// learnEarliestHeight records the lowest height available from the error of a
+// pruned node, e.g. "height 1 is not available, lowest height is 4000000".
+func (s *Server) learnEarliestHeight(body []byte) {
+	if m := lowestHeightRe.FindSubmatch(body); m != nil {
+		if h := parseHeight(string(m[1])); h > 0 {
+			s.earliestHeight.Store(h)
+		}
+	}
+}
+
+// learnLatestHeight records a height the server reached, ignoring it if the
+// server is already known to be further.
+func (s *Server) learnLatestHeight(h int64) {
+	for {
+		cur := s.latestHeight.Load()
+		if h <= cur || s.latestHeight.CompareAndSwap(cur, h) {
+			return
+		}
+	}
+}
+
+// learn updates what is known about the server from a response it gave, so
+// its state is more up to date than what the periodic probe tells.
+func (s *Server) learn(req *request, resp *response) {
+	switch resp.failure {
+	case PrunedHeight:
+		s.learnEarliestHeight(resp.body)
+	case NotIndexed:
+		s.setCapability(TxIndex, false)
+	case NoFailure:
+		if h := parseHeight(resp.header.Get(restHeightHeader)); h > 0 {
+			s.learnLatestHeight(h)
+		}
+		if len(req.calls) == 1 && !req.batch && req.calls[0].Method == "status" {
+			var status struct {
+				Result rpcStatus `json:"result"`
+			}
+			if json.Unmarshal(resp.body, &status) == nil {
+				if h := parseHeight(status.Result.SyncInfo.LatestBlockHeight); h > 0 {
+					s.learnLatestHeight(h)
+				}
+			}
+		}
+	}
+}
+
+// probe updates the server's retained block range. RPC servers report both
+// ends in /status, along with their tx indexing capability. REST servers
+// report the latest one with the latest block, and the earliest one as told by
+// probeEarliestHeight.
+func (s *Server) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ProxyRequestTimeout)
+	defer cancel()
+	switch s.kind {
+	case RPC:
+		var status rpcStatus
+		if err := s.get(ctx, "/status", &status); err != nil {
+			return err
+		}
+		if h := parseHeight(status.SyncInfo.EarliestBlockHeight); h > 0 {
+			s.earliestHeight.Store(h)
+		}
+		if h := parseHeight(status.SyncInfo.LatestBlockHeight); h > 0 {
+			s.latestHeight.Store(h)
+		}
+		if idx := status.NodeInfo.Other.TxIndex; idx != "" {
+			s.setCapability(TxIndex, idx == "on")
+		}
+	case Rest:
+		var block restLatestBlock
+		if err := s.get(ctx, "/cosmos/base/tendermint/v1beta1/blocks/latest", &block); err != nil {
+			return err
+		}
+		if h := parseHeight(block.Block.Header.Height); h > 0 {
+			s.latestHeight.Store(h)
+		}
+		return s.probeEarliestHeight(ctx)
+	}
+	return nil
+}
+
+// probeEarliestHeight asks a REST server for its earliest height, which nodes
+// report in their status since Cosmos SDK v0.50. Older ones are asked for the
+// first block instead, which pruned nodes reply their lowest height to.
+func (s *Server) probeEarliestHeight(ctx context.Context) error {
+	var status restNodeStatus
+	if err := s.get(ctx, "/cosmos/base/node/v1beta1/status", &status); err == nil {
+		if h := parseHeight(status.EarliestStoreHeight); h > 0 {
+			s.earliestHeight.Store(h)
+			return nil
+		}
+	}
+	const path = "/cosmos/base/tendermint/v1beta1/blocks/1"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url.JoinPath(path).String(), nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not get %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		s.earliestHeight.Store(1)
+		return nil
+	}
+	bts, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", path, err)
+	}
+	s.learnEarliestHeight(bts)
+	return nil
+}
+
+// get fetches a JSON document from the server, unwrapping it from a JSON-RPC
+// response if needed.
+func (s *Server) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url.JoinPath(path).String(), nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not get %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get %s: %s", path, resp.Status)
+	}
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", path, err)
+	}
+	var wrapped struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(bts, &wrapped); err == nil && len(wrapped.Result) > 0 {
+		bts = wrapped.Result
+	}
+	if err := json.Unmarshal(bts, v); err != nil {
+		return fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// probe probes all servers concurrently.
+func (p *Proxy) probe(ctx context.Context) {
+	p.mu.Lock()
+	servers := slices.Clone(p.servers)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.probe(ctx); err != nil {
+				slog.Warn("could not probe server", "name", srv.name, "err", err)
+			}
+			srv.probeCapabilities(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// refreshHeights probes servers in the background when requests need heights
+// that aren't known yet, unless they're already being probed.
+func (p *Proxy) refreshHeights() {
+	if !p.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer p.refreshing.Store(false)
+		p.probe(context.Background())
+	}()
+}
+
+// covering tells whether any server might retain the given height, also
+// returning the lowest and latest heights known across servers.
+func (p *Proxy) covering(height int64) (ok bool, lowest, latest int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.servers) == 0 {
+		return true, 0, 0
+	}
+	for _, srv := range p.servers {
+		if srv.covers(height) {
+			ok = true
+		}
+		if h := srv.earliestHeight.Load(); h > 0 && (lowest == 0 || h < lowest) {
+			lowest = h
+		}
+		latest = max(latest, srv.latestHeight.Load())
+	}
+	return ok, lowest, latest
+}
+
+// reached tells whether any server is known to have reached the given height,
+// also returning the latest height known.
+func (p *Proxy) reached(height int64) (bool, int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var latest int64
+	for _, srv := range p.servers {
+		latest = max(latest, srv.latestHeight.Load())
+	}
+	return latest >= height, latest
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func rpcNode(tb testing.TB, name string, earliest, latest int64) *httptest.Server {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/status" {
+			_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":-1,"result":{"sync_info":{"earliest_block_height":"%d","latest_block_height":"%d"}}}`, earliest, latest)
+			return
+		}
+		_, _ = io.WriteString(w, name)
+	}))
+	tb.Cleanup(srv.Close)
+	return srv
+}
+
+func TestArchiveRouting(t *testing.T) {
+	pruned := rpcNode(t, "pruned", 4000, 5000)
+	archive := rpcNode(t, "archive", 1000, 5000)
+
+	proxy := New(RPC, nil, testConfig())
+	require.NoError(t, proxy.doUpdate([]seed.Provider{
+		{Address: pruned.URL, Provider: "pruned"},
+		{Address: archive.URL, Provider: "archive"},
+	}))
+	proxy.probe(context.Background())
+
+	for _, st := range proxy.Stats() {
+		require.EqualValues(t, 5000, st.Latest)
+		if st.Name == "pruned" {
+			require.EqualValues(t, 4000, st.Earliest)
+		}
+	}
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	get := func(t *testing.T, path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(proxySrv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(bts)
+	}
+
+	for i := 0; i < 10; i++ {
+		status, body := get(t, "/block?height=2000")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "archive", body)
+	}
+
+	status, body := get(t, "/block?height=10")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Contains(t, body, "height 10 is not available")
+
+	// nodes reached heights past the last probe since.
+	status, _ = get(t, "/block?height=5001")
+	require.Equal(t, http.StatusOK, status)
+
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		_, body := get(t, "/block?height=4500")
+		seen[body] = true
+	}
+	require.Equal(t, map[string]bool{"archive": true, "pruned": true}, seen)
+}
+
+func TestProbeOnUpdate(t *testing.T) {
+	node := rpcNode(t, "node", 1, 100)
+	ch := make(chan seed.Seed)
+	proxy := New(RPC, ch, config.Config{
+		ProxyRequestTimeout: time.Second,
+		StatusProbeInterval: time.Hour,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proxy.Start(ctx)
+
+	ch <- seed.Seed{APIs: seed.Apis{RPC: []seed.Provider{{Address: node.URL, Provider: "node"}}}}
+	require.Eventually(t, func() bool {
+		st := proxy.Stats()
+		return len(st) == 1 && st[0].Latest == 100
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestLearnEarliestHeight(t *testing.T) {
+	srv, err := newServer("srv", "http://srv.local", RPC, config.Config{})
+	require.NoError(t, err)
+	require.True(t, srv.covers(1))
+	srv.learnEarliestHeight([]byte(`{"error":{"data":"height 1 is not available, lowest height is 4000000"}}`))
+	require.False(t, srv.covers(1))
+	require.True(t, srv.covers(4000000))
+}
+
+func TestProbeRestEarliestHeight(t *testing.T) {
+	for name, tt := range map[string]struct {
+		status   string
+		block    string
+		earliest int64
+	}{
+		"status":  {status: `{"earliest_store_height":"4000","height":"5000"}`, earliest: 4000},
+		"pruned":  {block: `{"code":2,"message":"height 1 is not available, lowest height is 3000","details":[]}`, earliest: 3000},
+		"archive": {earliest: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/cosmos/base/tendermint/v1beta1/blocks/latest":
+					_, _ = io.WriteString(w, `{"block":{"header":{"height":"5000"}}}`)
+				case "/cosmos/base/node/v1beta1/status":
+					if tt.status == "" {
+						w.WriteHeader(http.StatusNotImplemented)
+						return
+					}
+					_, _ = io.WriteString(w, tt.status)
+				case "/cosmos/base/tendermint/v1beta1/blocks/1":
+					if tt.block != "" {
+						w.WriteHeader(http.StatusInternalServerError)
+						_, _ = io.WriteString(w, tt.block)
+						return
+					}
+					_, _ = io.WriteString(w, `{"block":{"header":{"height":"1"}}}`)
+				}
+			}))
+			t.Cleanup(node.Close)
+
+			srv, err := newServer("srv", node.URL, Rest, testConfig())
+			require.NoError(t, err)
+			require.NoError(t, srv.probe(context.Background()))
+			require.EqualValues(t, 5000, srv.latestHeight.Load())
+			require.Equal(t, tt.earliest, srv.earliestHeight.Load())
+		})
+	}
+}
+
+func TestMinHeight(t *testing.T) {
+	behind := rpcNode(t, "behind", 1, 100)
+	ahead := rpcNode(t, "ahead", 1, 102)
+
+	proxy := New(RPC, nil, testConfig())
+	require.NoError(t, proxy.doUpdate([]seed.Provider{
+		{Address: behind.URL, Provider: "behind"},
+		{Address: ahead.URL, Provider: "ahead"},
+	}))
+	proxy.probe(context.Background())
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	get := func(t *testing.T, minHeight string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, proxySrv.URL+"/tx?hash=0xABCD", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Min-Height", minHeight)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(bts)
+	}
+
+	for i := 0; i < 10; i++ {
+		resp, body := get(t, "101")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "ahead", body)
+		require.Equal(t, "102", resp.Header.Get("X-Block-Height"))
+	}
+
+	resp, body := get(t, "200")
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	require.Contains(t, body, "no server reached height 200")
+
+	t.Run("unknown heights", func(t *testing.T) {
+		proxy := New(RPC, nil, testConfig())
+		require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: behind.URL, Provider: "behind"}}))
+		proxySrv = httptest.NewServer(proxy)
+		t.Cleanup(proxySrv.Close)
+
+		// before servers are probed, clients are told to retry.
+		resp, _ := get(t, "101")
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+		require.Eventually(t, func() bool {
+			resp, _ := get(t, "101")
+			return resp.StatusCode == http.StatusPreconditionFailed
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestLearnLatestHeight(t *testing.T) {
+	srv, err := newServer("srv", "http://srv.local", Rest, config.Config{})
+	require.NoError(t, err)
+	req := newRequest(Rest, httptest.NewRequest(http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", nil), nil)
+
+	srv.learn(req, &response{header: http.Header{"Grpc-Metadata-X-Cosmos-Block-Height": {"10"}}})
+	require.EqualValues(t, 10, srv.latestHeight.Load())
+
+	// stale replies don't move the height back.
+	srv.learn(req, &response{header: http.Header{"Grpc-Metadata-X-Cosmos-Block-Height": {"9"}}})
+	require.EqualValues(t, 10, srv.latestHeight.Load())
+}
+
+func TestBlockHeight(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cosmos/base/tendermint/v1beta1/blocks/latest" {
+			_, _ = io.WriteString(w, `{"block":{"header":{"height":"100"}}}`)
+			return
+		}
+		// the query was served by a node lagging behind its latest block.
+		w.Header().Set("Grpc-Metadata-X-Cosmos-Block-Height", "99")
+		_, _ = io.WriteString(w, r.Header.Get("X-Min-Height"))
+	}))
+	t.Cleanup(node.Close)
+
+	proxy := New(Rest, nil, testConfig())
+	require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: node.URL, Provider: "node"}}))
+	proxy.probe(context.Background())
+
+	req := httptest.NewRequest(http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", nil)
+	req.Header.Set("X-Min-Height", "90")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "99", rec.Header().Get("X-Block-Height"))
+	require.Empty(t, rec.Body.String())
+}
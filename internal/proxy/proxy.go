@@ -2,19 +2,39 @@ package proxy
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
+	"math"
 	"math/rand"
 	"net/http"
+	"net/netip"
+	"path/filepath"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/akash-network/rpc-proxy/internal/admission"
+	"github.com/akash-network/rpc-proxy/internal/auth"
+	"github.com/akash-network/rpc-proxy/internal/cache"
 	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
 	"github.com/akash-network/rpc-proxy/internal/seed"
+	"golang.org/x/sync/singleflight"
 )
 
+// maxRequestSize is the largest request body accepted. Bodies are read fully
+// so requests can be retried on other servers.
+const maxRequestSize = 10 << 20
+
+// healthRefreshInterval is how often the health of servers is refreshed,
+// besides after each of their requests.
+const healthRefreshInterval = time.Second
+
 type ProxyKind uint8
 
 const (
@@ -22,16 +42,56 @@ const (
 	Rest ProxyKind = iota
 )
 
+func (k ProxyKind) String() string {
+	if k == Rest {
+		return "rest"
+	}
+	return "rpc"
+}
+
+// Option configures optional features of a proxy.
+type Option func(*Proxy)
+
 func New(
 	kind ProxyKind,
 	ch chan seed.Seed,
 	cfg config.Config,
+	opts ...Option,
 ) *Proxy {
-	return &Proxy{
-		cfg:  cfg,
-		ch:   ch,
-		kind: kind,
+	broadcasters := cfg.RPCBroadcastServers
+	if kind == Rest {
+		broadcasters = cfg.RestBroadcastServers
 	}
+	rates := rateLimits(kind, cfg)
+	p := &Proxy{
+		cfg:             cfg,
+		ch:              ch,
+		kind:            kind,
+		affinity:        map[string]affinity{},
+		methods:         map[string]*methodStats{},
+		policyHits:      map[string]int64{},
+		trusted:         ratelimit.ParsePrefixes(cfg.TrustedProxies),
+		rates:           rates,
+		limiters:        newLimiters(rates),
+		limited:         map[string]int64{},
+		tierLimiters:    map[string]*ratelimit.Limiter{},
+		corsPolicy:      newCORSPolicy(kind, cfg),
+		admission:       newAdmission(cfg),
+		budgets:         ratelimit.NewBudgets(cfg.UpstreamRequestBudget),
+		providerBudgets: ratelimit.NewBudgets(cfg.ProviderRequestBudget),
+		broadcasters:    newBroadcasters(kind, broadcasters, cfg),
+	}
+	if cfg.CacheSize > 0 {
+		var dir string
+		if cfg.CacheDir != "" {
+			dir = filepath.Join(cfg.CacheDir, kind.String())
+		}
+		p.cache = cache.New(cfg.CacheSize, dir, cfg.CacheDiskMaxBytes)
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 type Proxy struct {
@@ -44,35 +104,131 @@ type Proxy struct {
 	mu      sync.Mutex
 	servers []*Server
 
+	// trusted servers transactions are broadcasted to, if any.
+	broadcasters []*Server
+
+	affinityMu sync.Mutex
+	affinity   map[string]affinity
+
+	// cache of responses, nil if disabled.
+	cache       *cache.Cache
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+
+	methodsMu sync.Mutex
+	methods   map[string]*methodStats
+
+	// how many requests each policy rule rejected or changed.
+	policyMu   sync.Mutex
+	policyHits map[string]int64
+
+	// proxies trusted to tell the client IP.
+	trusted []netip.Prefix
+
+	rates     [endpointClasses]ratelimit.Rate
+	limiters  [endpointClasses]*ratelimit.Limiter
+	limitedMu sync.Mutex
+	// how many times each client was rate limited.
+	limited map[string]int64
+
+	corsPolicy corsPolicy
+
+	// API keys, nil if clients are not authenticated.
+	keys           *auth.Store
+	tierLimitersMu sync.Mutex
+	tierLimiters   map[string]*ratelimit.Limiter
+
+	// request budgets of servers and providers.
+	budgets         *ratelimit.Budgets
+	providerBudgets *ratelimit.Budgets
+
+	// admission controller shedding load, nil if disabled.
+	admission *admission.Controller
+
+	// identical requests in flight, sharing a single upstream call.
+	inflight  singleflight.Group
+	coalesced atomic.Int64
+
+	// whether servers are being probed for heights requests need.
+	refreshing atomic.Bool
+
 	initialized  atomic.Bool
 	shuttingDown atomic.Bool
 }
 
-func (p *Proxy) Ready() bool { return p.initialized.Load() }
-func (p *Proxy) Live() bool  { return !p.shuttingDown.Load() && p.initialized.Load() }
+// Ready tells whether the proxy is initialized and not shedding too many
+// requests.
+func (p *Proxy) Ready() bool {
+	return p.initialized.Load() && p.admissionStats().ShedRate <= p.cfg.UnreadyShedPct
+}
+
+func (p *Proxy) Live() bool { return !p.shuttingDown.Load() && p.initialized.Load() }
 
 func (p *Proxy) Stats() []ServerStat {
+	p.mu.Lock()
+	servers := slices.Concat(p.servers, p.broadcasters)
+	p.mu.Unlock()
 	var result []ServerStat
-	for _, s := range p.servers {
+	for _, s := range servers {
 		reqCount := s.requestCount.Load()
+		_, unsupported := s.Capabilities()
+		used, budget := budgetUsed(s.budget)
+		providerUsed, providerBudget := budgetUsed(s.providerBudget)
 		result = append(result, ServerStat{
-			Name:        s.name,
-			URL:         s.url.String(),
-			Avg:         s.pings.Last(),
-			Degraded:    !s.Healthy(),
-			Initialized: reqCount > 0,
-			Requests:    reqCount,
-			ErrorRate:   s.ErrorRate(),
+			Name:               s.name,
+			URL:                s.url.String(),
+			Avg:                s.pings.Last(),
+			P50:                s.latency.Quantile(0.5),
+			P90:                s.latency.Quantile(0.9),
+			P99:                s.latency.Quantile(0.99),
+			Degraded:           !s.Healthy(),
+			Ejected:            s.Ejected(),
+			CoolingOff:         s.CoolingOff().Round(time.Second),
+			Initialized:        s.WarmedUp(),
+			Requests:           reqCount,
+			ErrorRate:          s.ErrorRate(),
+			Failures:           s.Failures(),
+			Earliest:           s.earliestHeight.Load(),
+			Latest:             s.latestHeight.Load(),
+			Unsupported:        unsupported.Names(),
+			StickyHits:         s.affinityHits.Load(),
+			Migrations:         s.affinityMigrations.Load(),
+			Broadcasts:         s.broadcasts.Load(),
+			BudgetUsed:         used,
+			Budget:             budget,
+			ProviderBudgetUsed: providerUsed,
+			ProviderBudget:     providerBudget,
+			Spillovers:         s.spillovers.Load(),
 		})
 	}
 	sort.Sort(serverStats(result))
 	return result
 }
 
+// ProxyStats returns the stats of the proxy as a whole.
+func (p *Proxy) ProxyStats() ProxyStat {
+	stat := ProxyStat{
+		CacheHits:   p.cacheHits.Load(),
+		CacheMisses: p.cacheMisses.Load(),
+		Coalesced:   p.coalesced.Load(),
+		Methods:     p.MethodStats(),
+		PolicyHits:  p.PolicyHits(),
+		TopLimited:  p.TopLimited(10),
+		Admission:   p.admissionStats(),
+	}
+	if p.cache != nil {
+		stat.CacheEntries, stat.CacheBytes = p.cache.Len()
+	}
+	return stat
+}
+
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if p.shuttingDown.Load() {
-		slog.Error("proxy is shutting down")
-		w.WriteHeader(http.StatusInternalServerError)
+		p.failWith(w, nil, errShuttingDown)
+		return
+	}
+
+	if p.cors(w, r) {
 		return
 	}
 
@@ -83,32 +239,199 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/rest")
 	}
 
-	if srv := p.next(); srv != nil {
-		srv.ServeHTTP(w, r)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		slog.Error("could not read request", "err", err)
+		p.fail(w, nil, http.StatusBadRequest, rpcInvalidRequest, grpcInvalidArgument, "could not read request")
+		return
+	}
+
+	var secret string
+	if p.keys != nil {
+		secret = apiKey(r)
+	}
+
+	req := newRequest(p.kind, r, body)
+	if limit := p.cfg.RPCMaxBatchSize; limit > 0 && len(req.calls) > limit {
+		slog.Warn("batch is too large", "calls", len(req.calls), "max", limit)
+		writeRPCError(w, http.StatusBadRequest, nil, rpcInvalidRequest, fmt.Sprintf("batch of %d calls exceeds the maximum of %d", len(req.calls), limit))
+		return
+	}
+
+	if !p.authenticate(w, req, secret) || !p.allow(w, req) || !p.withinQuota(w, req) {
+		return
+	}
+
+	if rej := p.check(req); rej != nil {
+		slog.Warn("request rejected by policy", "rule", rej.rule, "reason", rej.msg)
+		p.reject(w, rej)
+		return
+	}
+
+	if req.height > 0 {
+		if ok, lowest, latest := p.covering(req.height); !ok {
+			slog.Warn("no server has the requested height", "height", req.height)
+			p.fail(w, req, http.StatusBadRequest, rpcInvalidParams, grpcInvalidArgument,
+				fmt.Sprintf("height %d is not available, available heights are %d to %d", req.height, lowest, latest))
+			return
+		}
+	}
+
+	if req.minHeight > 0 {
+		switch ok, latest := p.reached(req.minHeight); {
+		case latest == 0:
+			// no height is known yet, e.g. before servers are probed.
+			p.refreshHeights()
+			w.Header().Set("Retry-After", "1")
+			p.fail(w, req, http.StatusServiceUnavailable, rpcInvalidRequest, grpcUnavailable,
+				fmt.Sprintf("heights of servers are not known yet to serve height %d, retry later", req.minHeight))
+			return
+		case !ok:
+			slog.Warn("no server reached the requested height", "height", req.minHeight, "latest", latest)
+			p.fail(w, req, http.StatusPreconditionFailed, rpcInvalidRequest, grpcFailedPrecondition,
+				fmt.Sprintf("no server reached height %d yet, latest height is %d", req.minHeight, latest))
+			return
+		}
+	}
+
+	if !p.capable(req.needs) {
+		slog.Warn("no server has the requested capabilities", "capabilities", req.needs)
+		p.fail(w, req, http.StatusNotImplemented, rpcMethodNotFound, grpcUnimplemented, fmt.Sprintf("no server supports %s", req.needs))
+		return
+	}
+
+	ttl, cacheable := p.cacheable(req)
+	key := cacheKey(req)
+	if cacheable && p.cached(w, key) {
+		return
+	}
+
+	release, ok := p.admit(w, req)
+	if !ok {
+		return
+	}
+	var resp *response
+	switch {
+	case req.broadcast && !req.batch:
+		resp = p.broadcast(req)
+	case req.broadcast, p.splittable(req):
+		// batches are split so only their broadcasts are fanned out.
+		resp = p.split(req, p.affinityKey(w, r))
+	default:
+		session := p.affinityKey(w, r)
+		req.stream = !p.coalescible(req)
+		req.read = cacheable || len(req.calls) > 0
+		resp = p.coalesce(req, func(req *request) *response { return p.forward(req, session) })
+	}
+	release()
+	if resp != nil {
+		if cacheable {
+			p.store(key, ttl, resp)
+		}
+		if resp.height > 0 {
+			w.Header().Set(blockHeightHeader, strconv.FormatInt(resp.height, 10))
+		}
+		resp.writeTo(w)
 		return
 	}
-	slog.Error("no servers available")
-	w.WriteHeader(http.StatusInternalServerError)
+	p.failWith(w, req, errNoServers)
 }
 
-func (p *Proxy) next() *Server {
-	p.mu.Lock()
-	if len(p.servers) == 0 {
-		p.mu.Unlock()
-		return nil
+// forward sends the request to the next server, retrying on other servers
+// when it fails in a way another server could answer it.
+func (p *Proxy) forward(req *request, key string) *response {
+	var tried []*Server
+	var last *response
+	for attempt := 0; attempt <= p.cfg.RetryAttempts; attempt++ {
+		srv := p.pick(req, key, tried...)
+		if srv == nil {
+			break
+		}
+		last = srv.do(req)
+		if !last.retriable() {
+			break
+		}
+		slog.Warn("retrying request on another server", "name", srv.name, "failure", last.failure)
+		tried = append(tried, srv)
 	}
-	server := p.servers[p.round%len(p.servers)]
-	p.round++
+	p.recordMethods(req, last)
+	return last
+}
+
+// refreshHealth refreshes the health of every server, so it's up to date for
+// servers that get no requests too.
+func (p *Proxy) refreshHealth() {
+	p.mu.Lock()
+	servers := slices.Clone(p.servers)
 	p.mu.Unlock()
-	if server.Healthy() && server.ErrorRate() <= p.cfg.HealthyErrorRateThreshold {
-		return server
+	for _, srv := range servers {
+		srv.refreshHealth()
 	}
-	if rand.Intn(99)+1 < p.cfg.UnhealthyServerRecoverChancePct {
-		slog.Warn("giving slow server a chance", "name", server.name, "avg", server.pings.Last())
+}
+
+// next picks the next server in the round robin, skipping cooling off,
+// ejected and unhealthy servers and part of the requests of servers that are
+// warming up, as of their last health refresh. If all servers are skipped, the first one skipped is used
+// anyway, preferring warming up, then unhealthy, then ejected, then cooling
+// off ones. Excluded servers and servers that can't serve the request are
+// never picked, nor servers over their request budget.
+func (p *Proxy) next(req *request, exclude ...*Server) *Server {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var warming, unhealthy, ejected, cooling *Server
+	for range p.servers {
+		server := p.servers[p.round%len(p.servers)]
+		p.round++
+		if slices.Contains(exclude, server) || !server.eligible(req) {
+			continue
+		}
+		if server.overBudget() {
+			server.spillovers.Add(1)
+			continue
+		}
+		if server.CoolingOff() > 0 {
+			if cooling == nil {
+				cooling = server
+			}
+			continue
+		}
+		if server.Ejected() {
+			if ejected == nil {
+				ejected = server
+			}
+			continue
+		}
+		if !server.healthy.Load() {
+			latency := time.Duration(server.lastLatency.Load())
+			if rand.Intn(100) < p.cfg.UnhealthyServerRecoverChancePct {
+				slog.Warn("giving slow server a chance", "name", server.name, "latency", latency)
+				return server
+			}
+			slog.Warn("server is too slow, trying next", "name", server.name, "latency", latency)
+			if unhealthy == nil {
+				unhealthy = server
+			}
+			continue
+		}
+		if w := math.Float64frombits(server.lastWeight.Load()); w < 1 && rand.Float64() >= w {
+			slog.Debug("server is warming up, trying next", "name", server.name, "weight", w)
+			if warming == nil {
+				warming = server
+			}
+			continue
+		}
 		return server
 	}
-	slog.Warn("server is too slow, trying next", "name", server.name, "avg", server.pings.Last())
-	return p.next()
+	if warming != nil {
+		return warming
+	}
+	if unhealthy != nil {
+		return unhealthy
+	}
+	if ejected != nil {
+		return ejected
+	}
+	return cooling
 }
 
 func (p *Proxy) update(seed seed.Seed) {
@@ -135,11 +458,14 @@ func (p *Proxy) doUpdate(providers []seed.Provider) error {
 			srv, err := newServer(
 				provider.Provider,
 				provider.Address,
+				p.kind,
 				p.cfg,
 			)
 			if err != nil {
 				return err
 			}
+			srv.budget = p.budgets.Get(srv.name)
+			srv.providerBudget = p.providerBudgets.Get(srv.name)
 			p.servers = append(p.servers, srv)
 		}
 	}
@@ -152,6 +478,7 @@ func (p *Proxy) doUpdate(providers []seed.Provider) error {
 			}
 		}
 		slog.Info("server was removed from pool", "name", srv.name)
+		srv.removed.Store(true)
 		return true
 	})
 
@@ -163,15 +490,60 @@ func (p *Proxy) doUpdate(providers []seed.Provider) error {
 func (p *Proxy) Start(ctx context.Context) {
 	p.init.Do(func() {
 		go func() {
+			health := time.NewTicker(healthRefreshInterval)
+			defer health.Stop()
+			var outliers, sessions, limits <-chan time.Time
+			if p.cfg.OutlierDetectionInterval > 0 {
+				t := time.NewTicker(p.cfg.OutlierDetectionInterval)
+				defer t.Stop()
+				outliers = t.C
+			}
+			if p.cfg.Affinity != "" && p.cfg.AffinityTTL > 0 {
+				t := time.NewTicker(p.cfg.AffinityTTL)
+				defer t.Stop()
+				sessions = t.C
+			}
+			if p.keys != nil || slices.ContainsFunc(p.limiters[:], func(l *ratelimit.Limiter) bool { return l != nil }) {
+				t := time.NewTicker(time.Minute)
+				defer t.Stop()
+				limits = t.C
+			}
 			for {
 				select {
 				case seed := <-p.ch:
 					p.update(seed)
+					// new servers are probed right away rather than on
+					// the next tick, so their heights are known.
+					if p.cfg.StatusProbeInterval > 0 {
+						go p.probe(ctx)
+					}
+				case <-health.C:
+					p.refreshHealth()
+				case <-outliers:
+					p.detectOutliers()
+				case <-sessions:
+					p.expireSessions()
+				case <-limits:
+					p.cleanupLimiters()
 				case <-ctx.Done():
 					p.shuttingDown.Store(true)
 					return
 				}
 			}
 		}()
+		if p.cfg.StatusProbeInterval > 0 {
+			go func() {
+				t := time.NewTicker(p.cfg.StatusProbeInterval)
+				defer t.Stop()
+				for {
+					select {
+					case <-t.C:
+						p.probe(ctx)
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
 	})
 }
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,6 +27,19 @@ func TestProxy(t *testing.T) {
 	}
 }
 
+// testConfig returns the config of proxies under test, whose servers are
+// healthy as long as they reply within a second.
+func testConfig() config.Config {
+	return config.Config{
+		HealthyThreshold:              time.Second,
+		HealthyPercentile:             90,
+		LatencyWindow:                 time.Minute,
+		HealthyErrorRateThreshold:     10,
+		HealthyErrorRateBucketTimeout: time.Minute,
+		ProxyRequestTimeout:           time.Second,
+	}
+}
+
 func testProxy(tb testing.TB, kind ProxyKind) {
 	srv1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, _ = io.WriteString(w, "srv1 replied")
@@ -37,18 +51,16 @@ func testProxy(tb testing.TB, kind ProxyKind) {
 	}))
 	tb.Cleanup(srv2.Close)
 	srv3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusTeapot)
+		w.WriteHeader(http.StatusBadGateway)
 	}))
 	tb.Cleanup(srv2.Close)
 
 	ch := make(chan seed.Seed, 1)
-	proxy := New(kind, ch, config.Config{
-		HealthyThreshold:                10 * time.Millisecond,
-		ProxyRequestTimeout:             time.Second,
-		UnhealthyServerRecoverChancePct: 1,
-		HealthyErrorRateThreshold:       10,
-		HealthyErrorRateBucketTimeout:   time.Second * 10,
-	})
+	cfg := testConfig()
+	cfg.HealthyThreshold = 100 * time.Millisecond
+	cfg.UnhealthyServerRecoverChancePct = 1
+	cfg.HealthyErrorRateBucketTimeout = time.Second * 10
+	proxy := New(kind, ch, cfg)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	tb.Cleanup(cancel)
@@ -98,7 +110,7 @@ func testProxy(tb testing.TB, kind ProxyKind) {
 			}
 			defer resp.Body.Close()
 			// only two status codes accepted
-			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusTeapot {
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadGateway {
 				bts, _ := io.ReadAll(resp.Body)
 				return fmt.Errorf("bad status code: %v: %s", resp.StatusCode, string(bts))
 			}
@@ -130,10 +142,126 @@ func testProxy(tb testing.TB, kind ProxyKind) {
 	require.Zero(tb, srv1Stats.ErrorRate)
 	require.Zero(tb, srv2Stats.ErrorRate)
 	require.Equal(tb, float64(100), srv3Stats.ErrorRate)
+	require.Equal(tb, map[string]int64{"upstream_5xx": srv3Stats.Requests}, srv3Stats.Failures)
 	require.Greater(tb, srv1Stats.Requests, srv2Stats.Requests)
 	require.Greater(tb, srv2Stats.Avg, srv1Stats.Avg)
+	require.Greater(tb, srv2Stats.P90, srv1Stats.P90)
+	require.GreaterOrEqual(tb, srv2Stats.P99, srv2Stats.P50)
 	require.False(tb, srv1Stats.Degraded)
 	require.True(tb, srv2Stats.Degraded)
 	require.True(tb, srv1Stats.Initialized)
 	require.True(tb, srv2Stats.Initialized)
 }
+
+func TestSlowStart(t *testing.T) {
+	cfg := testConfig()
+	cfg.SlowStartDuration = time.Hour
+	cfg.SlowStartRequests = 10
+	proxy := New(RPC, nil, cfg)
+	require.NoError(t, proxy.doUpdate([]seed.Provider{
+		{Address: "http://srv1.local", Provider: "srv1"},
+		{Address: "http://srv2.local", Provider: "srv2"},
+	}))
+
+	warm := proxy.servers[0]
+	warm.warmStart.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+	warm.warmRequests.Store(10)
+	warm.refreshHealth()
+	require.True(t, warm.WarmedUp())
+	require.False(t, proxy.servers[1].WarmedUp())
+
+	picks := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		picks[proxy.next(nil).name]++
+	}
+	require.Greater(t, picks["srv2"], 0)
+	require.Less(t, picks["srv2"], 200)
+
+	for _, st := range proxy.Stats() {
+		require.Equal(t, st.Name == "srv1", st.Initialized)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	}))
+	t.Cleanup(node.Close)
+	srv, err := newServer("srv", node.URL, RPC, testConfig())
+	require.NoError(t, err)
+	do := func() {
+		srv.do(newRequest(RPC, httptest.NewRequest(http.MethodGet, "/status", nil), nil))
+	}
+
+	// a fast tryout doesn't wipe out the slow requests before it.
+	srv.latency.Next(2 * time.Second)
+	do()
+	require.False(t, srv.Healthy())
+	require.EqualValues(t, 2, srv.latency.Count())
+
+	// it warms up again once the slow requests are out of the window.
+	srv.latency.Reset()
+	do()
+	require.True(t, srv.Healthy())
+	require.Zero(t, srv.warmRequests.Load())
+	do()
+	require.EqualValues(t, 1, srv.warmRequests.Load())
+}
+
+func TestRefreshHealth(t *testing.T) {
+	proxy := New(RPC, nil, testConfig())
+	require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: "http://srv.local", Provider: "srv"}}))
+	srv := proxy.servers[0]
+
+	// picking a server relies on its health as of the last refresh.
+	srv.latency.Next(2 * time.Second)
+	require.True(t, srv.healthy.Load())
+	proxy.refreshHealth()
+	require.False(t, srv.healthy.Load())
+
+	// servers getting no requests recover as slow samples decay.
+	srv.latency.Reset()
+	proxy.refreshHealth()
+	require.True(t, srv.healthy.Load())
+}
+
+func TestRetry(t *testing.T) {
+	pruned := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"Internal error","data":"height 1 is not available, lowest height is 4000000"}}`)
+	}))
+	t.Cleanup(pruned.Close)
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bts, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(bts)
+	}))
+	t.Cleanup(archive.Close)
+
+	cfg := testConfig()
+	cfg.InspectResponses = true
+	cfg.RetryAttempts = 1
+	proxy := New(RPC, nil, cfg)
+	require.NoError(t, proxy.doUpdate([]seed.Provider{
+		{Address: pruned.URL, Provider: "pruned"},
+		{Address: archive.URL, Provider: "archive"},
+	}))
+
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	for i := 0; i < 10; i++ {
+		resp, err := http.Post(proxySrv.URL, "application/json", strings.NewReader(`{"method":"block"}`))
+		require.NoError(t, err)
+		bts, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, `{"method":"block"}`, string(bts))
+	}
+
+	for _, st := range proxy.Stats() {
+		if st.Name == "pruned" {
+			require.Equal(t, map[string]int64{"pruned_height": st.Requests}, st.Failures)
+			require.False(t, st.Degraded)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
+)
+
+// endpointClass is a class of endpoints rate limited separately.
+type endpointClass uint8
+
+const (
+	defaultClass endpointClass = iota
+	heavyClass
+	broadcastClass
+	endpointClasses
+)
+
+var endpointClassNames = [endpointClasses]string{"default", "heavy", "broadcast"}
+
+func (c endpointClass) String() string { return endpointClassNames[c] }
+
+// rpcHeavyMethods are the JSON-RPC methods expensive for nodes to serve.
+var rpcHeavyMethods = map[string]bool{
+	"tx_search":       true,
+	"block_search":    true,
+	"blockchain":      true,
+	"block_results":   true,
+	"genesis":         true,
+	"genesis_chunked": true,
+	"unconfirmed_txs": true,
+}
+
+// restHeavyPrefixes are the REST endpoints expensive for nodes to serve,
+// besides searching txs.
+var restHeavyPrefixes = []string{
+	"/cosmos/tx/v1beta1/txs/block/",
+	"/cosmos/base/tendermint/v1beta1/validatorsets/",
+	"/cosmos/bank/v1beta1/denoms_metadata",
+	"/cosmos/bank/v1beta1/supply",
+	"/cosmos/gov/v1beta1/proposals",
+	"/cosmos/staking/v1beta1/validators",
+}
+
+// maxLimitedClients is how many rate limited clients are kept track of.
+const maxLimitedClients = 1000
+
+// JSON-RPC error code of rate limited requests.
+const rpcLimitExceeded = -32005
+
+// newLimiters creates the rate limiters of each endpoint class of a kind.
+func newLimiters(rates [endpointClasses]ratelimit.Rate) [endpointClasses]*ratelimit.Limiter {
+	var result [endpointClasses]*ratelimit.Limiter
+	for class, rate := range rates {
+		if rate.Enabled() {
+			result[class] = ratelimit.New(rate)
+		}
+	}
+	return result
+}
+
+// rateLimits returns the rate limits of each endpoint class of a kind.
+func rateLimits(kind ProxyKind, cfg config.Config) [endpointClasses]ratelimit.Rate {
+	if kind == Rest {
+		return [endpointClasses]ratelimit.Rate{cfg.RestRateLimit, cfg.RestHeavyRateLimit, cfg.RestBroadcastRateLimit}
+	}
+	return [endpointClasses]ratelimit.Rate{cfg.RPCRateLimit, cfg.RPCHeavyRateLimit, cfg.RPCBroadcastRateLimit}
+}
+
+// endpointClass classifies the request for rate limiting.
+func (p *Proxy) endpointClass(req *request) endpointClass {
+	if req.broadcast {
+		return broadcastClass
+	}
+	switch p.kind {
+	case RPC:
+		for _, call := range req.calls {
+			if rpcHeavyMethods[call.Method] {
+				return heavyClass
+			}
+		}
+	case Rest:
+		if req.Method == http.MethodGet && req.URL.Path == restBroadcastPath {
+			return heavyClass
+		}
+		for _, prefix := range restHeavyPrefixes {
+			if strings.HasPrefix(req.URL.Path, prefix) {
+				return heavyClass
+			}
+		}
+	}
+	return defaultClass
+}
+
+// client identifies the client for rate limiting: by the name of its API
+// key, by the RATE_LIMIT_HEADER if set by a trusted proxy, or by IP.
+func (p *Proxy) client(req *request) string {
+	if req.client != nil {
+		return req.client.Name
+	}
+	if p.cfg.RateLimitHeader != "" && ratelimit.FromTrusted(req.Request, p.trusted) {
+		if id := req.Header.Get(p.cfg.RateLimitHeader); id != "" {
+			return id
+		}
+	}
+	return ratelimit.ClientIP(req.Request, p.trusted)
+}
+
+// limiter returns the rate limiter of the request's endpoint class, and its
+// rate. Clients with an API key are limited according to their tier.
+func (p *Proxy) limiter(req *request, class endpointClass) (*ratelimit.Limiter, ratelimit.Rate) {
+	if req.client == nil {
+		return p.limiters[class], p.rates[class]
+	}
+	tier := req.client.Tier
+	rate := [endpointClasses]ratelimit.Rate{tier.RateLimit, tier.HeavyRateLimit, tier.BroadcastRateLimit}[class]
+	if !rate.Enabled() {
+		return nil, rate
+	}
+	// tiers might change when keys are reloaded, so limiters are per rate.
+	key := fmt.Sprintf("%s/%s/%s", req.client.TierName, class, rate)
+	p.tierLimitersMu.Lock()
+	defer p.tierLimitersMu.Unlock()
+	limiter, ok := p.tierLimiters[key]
+	if !ok {
+		limiter = ratelimit.New(rate)
+		p.tierLimiters[key] = limiter
+	}
+	return limiter, rate
+}
+
+// allow rate limits the request, replying with 429 Too Many Requests if the
+// client is over its limit. Each call of a batch counts as a request.
+func (p *Proxy) allow(w http.ResponseWriter, req *request) bool {
+	class := p.endpointClass(req)
+	limiter, rate := p.limiter(req, class)
+	if limiter == nil {
+		return true
+	}
+	client := p.client(req)
+	res := limiter.Allow(client, max(1, len(req.calls)))
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+	if res.Allowed {
+		return true
+	}
+
+	slog.Warn("client is rate limited", "client", client, "class", class)
+	p.countLimited(client)
+	h.Set("Retry-After", strconv.Itoa(max(1, seconds(res.RetryAfter))))
+	p.fail(w, req, http.StatusTooManyRequests, rpcLimitExceeded, grpcResourceExhausted,
+		fmt.Sprintf("rate limit of %s exceeded for %s requests", rate, class))
+	return false
+}
+
+func seconds(d time.Duration) int { return int(math.Ceil(d.Seconds())) }
+
+func (p *Proxy) countLimited(client string) {
+	p.limitedMu.Lock()
+	defer p.limitedMu.Unlock()
+	if _, ok := p.limited[client]; !ok && len(p.limited) >= maxLimitedClients {
+		// make room by forgetting the least limited client.
+		var least string
+		for c, n := range p.limited {
+			if least == "" || n < p.limited[least] {
+				least = c
+			}
+		}
+		delete(p.limited, least)
+	}
+	p.limited[client]++
+}
+
+// TopLimited returns the n clients rate limited the most.
+func (p *Proxy) TopLimited(n int) []LimitedClient {
+	p.limitedMu.Lock()
+	defer p.limitedMu.Unlock()
+	result := make([]LimitedClient, 0, len(p.limited))
+	for client, count := range p.limited {
+		result = append(result, LimitedClient{Client: client, Limited: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Limited != result[j].Limited {
+			return result[i].Limited > result[j].Limited
+		}
+		return result[i].Client < result[j].Client
+	})
+	return result[:min(n, len(result))]
+}
+
+// cleanupLimiters forgets clients that are back under their limits, and
+// limiters of tiers that are not used anymore.
+func (p *Proxy) cleanupLimiters() {
+	for _, limiter := range p.limiters {
+		if limiter != nil {
+			limiter.Cleanup()
+		}
+	}
+	p.tierLimitersMu.Lock()
+	defer p.tierLimitersMu.Unlock()
+	for key, limiter := range p.tierLimiters {
+		limiter.Cleanup()
+		if limiter.Len() == 0 {
+			delete(p.tierLimiters, key)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/config"
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
+	"github.com/akash-network/rpc-proxy/internal/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	}))
+	t.Cleanup(node.Close)
+
+	cfg := testConfig()
+	cfg.TrustedProxies = []string{"127.0.0.1"}
+	cfg.RPCRateLimit = ratelimit.Rate{Requests: 3, Per: time.Hour}
+	cfg.RPCHeavyRateLimit = ratelimit.Rate{Requests: 1, Per: time.Hour}
+	proxy := New(RPC, nil, cfg)
+	require.NoError(t, proxy.doUpdate([]seed.Provider{{Address: node.URL, Provider: "node"}}))
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	post := func(t *testing.T, client, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, proxySrv.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Forwarded-For", client)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+	const status = `{"jsonrpc":"2.0","id":1,"method":"status"}`
+
+	resp := post(t, "1.1.1.1", status)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "3", resp.Header.Get("RateLimit-Limit"))
+	require.Equal(t, "2", resp.Header.Get("RateLimit-Remaining"))
+
+	// batches count each call.
+	resp = post(t, "1.1.1.1", "["+status+","+status+"]")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	resp = post(t, "1.1.1.1", status)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1200", resp.Header.Get("Retry-After"))
+	var reply rpcReply
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.JSONEq(t, `1`, string(reply.ID))
+	require.Equal(t, rpcLimitExceeded, reply.Error.Code)
+	require.Equal(t, "rate limit of 3/h exceeded for default requests", reply.Error.Message)
+
+	// heavy methods have their own limit.
+	const search = `{"jsonrpc":"2.0","id":1,"method":"tx_search","params":{"query":"tx.height=1"}}`
+	require.Equal(t, http.StatusOK, post(t, "1.1.1.1", search).StatusCode)
+	require.Equal(t, http.StatusTooManyRequests, post(t, "1.1.1.1", search).StatusCode)
+
+	// other clients have their own limits.
+	require.Equal(t, http.StatusOK, post(t, "2.2.2.2", status).StatusCode)
+	require.Equal(t, http.StatusOK, post(t, "2.2.2.2", search).StatusCode)
+	require.Equal(t, http.StatusTooManyRequests, post(t, "2.2.2.2", search).StatusCode)
+
+	require.Equal(t, []LimitedClient{
+		{Client: "1.1.1.1", Limited: 2},
+		{Client: "2.2.2.2", Limited: 1},
+	}, proxy.TopLimited(10))
+	require.Len(t, proxy.TopLimited(1), 1)
+}
+
+func TestRestRateLimit(t *testing.T) {
+	proxy := New(Rest, nil, config.Config{
+		RestRateLimit:          ratelimit.Rate{Requests: 1, Per: time.Minute},
+		RestBroadcastRateLimit: ratelimit.Rate{Requests: 1, Per: time.Minute},
+	})
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+
+	// the first request isn't limited, but there are no servers.
+	resp, err := http.Get(proxySrv.URL + "/cosmos/bank/v1beta1/params")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	resp, err = http.Get(proxySrv.URL + "/cosmos/bank/v1beta1/params")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "60", resp.Header.Get("Retry-After"))
+	var status struct {
+		Code int `json:"code"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	require.Equal(t, grpcResourceExhausted, status.Code)
+
+	// heavy endpoints are not limited.
+	resp, err = http.Get(proxySrv.URL + "/cosmos/tx/v1beta1/txs?events=tx.height=1")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestRateLimitClient(t *testing.T) {
+	proxy := New(RPC, nil, config.Config{
+		RateLimitHeader: "X-Client",
+		TrustedProxies:  []string{"10.0.0.1"},
+	})
+	client := func(remote string) string {
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Client", "gateway-user")
+		return proxy.client(newRequest(RPC, r, nil))
+	}
+	require.Equal(t, "gateway-user", client("10.0.0.1:1234"))
+	// clients can't pick who they are counted as.
+	require.Equal(t, "1.2.3.4", client("1.2.3.4:1234"))
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/akash-network/rpc-proxy/internal/auth"
+)
+
+// request is a client request with its body already read, so it can be sent
+// to more than one server, and what's needed to route it.
+type request struct {
+	*http.Request
+	body []byte
+	// calls are the JSON-RPC calls of RPC requests, either from the URI
+	// (/method?param=value, whatever the HTTP method) or from the body of
+	// requests to the root, which might be a batch.
+	calls []rpcCall
+	batch bool
+	// height is the block height the request is for, or 0 if it isn't for a
+	// specific height.
+	height int64
+	// minHeight is the height a server must have reached to serve the
+	// request, so clients can read their own writes.
+	minHeight int64
+	// needs are the capabilities a server needs to serve the request.
+	needs Capability
+	// broadcast tells whether the request broadcasts a transaction.
+	broadcast bool
+	// invalid is why the JSON-RPC body is invalid, if it is.
+	invalid *rejection
+	// client is the client authenticated by API key, if any.
+	client *auth.Client
+	// stream tells whether only the client needs the whole response, so it
+	// can be streamed rather than buffered when nothing else reads it, or when
+	// it's too large to be read.
+	stream bool
+	// read tells whether the proxy reads the response, e.g. to cache it or to
+	// tell the failures of JSON-RPC calls apart.
+	read bool
+}
+
+const (
+	// minHeightHeader is set by clients to only be routed to servers that
+	// reached at least the given height. It is never sent to nodes.
+	minHeightHeader = "X-Min-Height"
+	// blockHeightHeader is replied to clients with the height the request was
+	// served at, so they can carry it forward.
+	blockHeightHeader = "X-Block-Height"
+)
+
+func newRequest(kind ProxyKind, r *http.Request, body []byte) *request {
+	req := &request{
+		Request:   r,
+		body:      body,
+		minHeight: parseHeight(r.Header.Get(minHeightHeader)),
+	}
+	r.Header.Del(minHeightHeader)
+	switch kind {
+	case RPC:
+		req.calls, req.batch, req.invalid = parseRPC(r, body)
+		if len(req.calls) == 1 {
+			req.height = req.calls[0].height()
+		}
+		for _, call := range req.calls {
+			req.needs |= rpcCapabilities[call.Method]
+		}
+	case Rest:
+		req.height = restHeight(r)
+		req.needs = restCapability(r)
+	}
+	req.broadcast = isBroadcast(kind, r, req.calls)
+	return req
+}
+
+type rpcCall struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	// raw is the call as sent by the client, so it can be sent on its own
+	// when splitting a batch.
+	raw json.RawMessage
+}
+
+// height returns the height param of the call, if any.
+func (c rpcCall) height() int64 { return parseHeight(c.param("height")) }
+
+// param returns a named param of the call as a string, unquoted as URI
+// params are, or "" if it isn't set.
+func (c rpcCall) param(name string) string {
+	var params map[string]json.RawMessage
+	if len(c.Params) == 0 || json.Unmarshal(c.Params, &params) != nil {
+		return ""
+	}
+	return strings.Trim(rawString(params[name]), `"`)
+}
+
+// rawString returns the value of a JSON string or number.
+func rawString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// parseRPC parses the JSON-RPC calls of a request, also telling whether it's a
+// batch. Nodes serve the method of the path whatever the HTTP method, with the
+// query as named params, so the body only holds calls for requests to the
+// root. Bodies that aren't valid JSON-RPC are rejected.
+func parseRPC(r *http.Request, body []byte) ([]rpcCall, bool, *rejection) {
+	if method := strings.TrimPrefix(r.URL.Path, "/"); method != "" {
+		params := map[string]string{}
+		for k, v := range r.URL.Query() {
+			params[k] = v[0]
+		}
+		bts, _ := json.Marshal(params)
+		return []rpcCall{{Method: method, Params: bts}}, false, nil
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, false, nil
+	}
+	if !json.Valid(body) {
+		return nil, false, invalidRPC(rpcParseError, "parse error")
+	}
+	if body[0] == '[' {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(body, &raws); err != nil || len(raws) == 0 {
+			return nil, true, invalidRPC(rpcInvalidRequest, "invalid batch")
+		}
+		calls := make([]rpcCall, 0, len(raws))
+		for _, raw := range raws {
+			var call rpcCall
+			if err := json.Unmarshal(raw, &call); err != nil || call.Method == "" {
+				return nil, true, invalidRPC(rpcInvalidRequest, "invalid call in batch")
+			}
+			call.raw = raw
+			calls = append(calls, call)
+		}
+		return calls, true, nil
+	}
+	var call rpcCall
+	if err := json.Unmarshal(body, &call); err != nil || call.Method == "" {
+		return nil, false, invalidRPC(rpcInvalidRequest, "invalid request")
+	}
+	call.raw = body
+	return []rpcCall{call}, false, nil
+}
+
+func invalidRPC(code int, msg string) *rejection {
+	return &rejection{rule: "invalid_request", status: http.StatusBadRequest, code: code, msg: msg}
+}
+
+// restHeightPaths are REST endpoints that take the height as last path
+// segment.
+var restHeightPaths = []string{
+	"/cosmos/base/tendermint/v1beta1/blocks/",
+	"/cosmos/base/tendermint/v1beta1/validatorsets/",
+}
+
+// restHeight finds the height of queries made at a given height, either with
+// the x-cosmos-block-height header or with a height in the path.
+func restHeight(r *http.Request) int64 {
+	if h := r.Header.Get("x-cosmos-block-height"); h != "" {
+		return parseHeight(h)
+	}
+	for _, prefix := range restHeightPaths {
+		if h, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+			return parseHeight(h)
+		}
+	}
+	return 0
+}
+
+// parseHeight parses a height that might be quoted, returning 0 if it isn't a
+// valid height.
+func parseHeight(s string) int64 {
+	h, err := strconv.ParseInt(strings.Trim(s, `"`), 10, 64)
+	if err != nil || h < 0 {
+		return 0
+	}
+	return h
+}
+
+// eligible tells whether the server can serve the request at all.
+func (s *Server) eligible(req *request) bool {
+	return req == nil ||
+		((req.height == 0 || s.covers(req.height)) &&
+			(req.minHeight == 0 || s.latestHeight.Load() >= req.minHeight) &&
+			s.supports(req.needs))
+}
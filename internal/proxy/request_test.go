@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestHeight(t *testing.T) {
+	for name, tc := range map[string]struct {
+		kind     ProxyKind
+		method   string
+		target   string
+		header   http.Header
+		body     string
+		expected int64
+	}{
+		"rpc uri":          {RPC, http.MethodGet, "/block?height=123", nil, "", 123},
+		"rpc uri quoted":   {RPC, http.MethodGet, `/block?height="123"`, nil, "", 123},
+		"rpc uri latest":   {RPC, http.MethodGet, "/block", nil, "", 0},
+		"rpc post":         {RPC, http.MethodPost, "/", nil, `{"jsonrpc":"2.0","id":1,"method":"block","params":{"height":"456"}}`, 456},
+		"rpc post number":  {RPC, http.MethodPost, "/", nil, `{"jsonrpc":"2.0","id":1,"method":"block","params":{"height":456}}`, 456},
+		"rpc post latest":  {RPC, http.MethodPost, "/", nil, `{"jsonrpc":"2.0","id":1,"method":"status"}`, 0},
+		"rpc post invalid": {RPC, http.MethodPost, "/", nil, `{"jsonrpc":`, 0},
+		"rest header": {
+			Rest, http.MethodGet, "/cosmos/bank/v1beta1/balances/akash1", http.Header{"X-Cosmos-Block-Height": {"789"}}, "", 789,
+		},
+		"rest path":   {Rest, http.MethodGet, "/cosmos/base/tendermint/v1beta1/blocks/321", nil, "", 321},
+		"rest latest": {Rest, http.MethodGet, "/cosmos/base/tendermint/v1beta1/blocks/latest", nil, "", 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+			require.Equal(t, tc.expected, newRequest(tc.kind, r, []byte(tc.body)).height)
+		})
+	}
+}
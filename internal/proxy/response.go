@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// response is an upstream response, fully read so it can be inspected before
+// deciding to send it to the client or to retry on another server, unless
+// only the client reads it.
+type response struct {
+	server *Server
+	status int
+	header http.Header
+	body   []byte
+	// stream is the body of the node streamed to the client instead, if
+	// set.
+	stream  io.ReadCloser
+	failure Failure
+	latency time.Duration
+	// height is the height the response was served at, as told by the node or
+	// else the latest height known of the server.
+	height int64
+}
+
+// stream is the body of a node response being streamed, which ends the
+// request to the node once closed.
+type stream struct {
+	io.Reader
+	body   io.Closer
+	cancel context.CancelFunc
+}
+
+func (s *stream) Close() error {
+	defer s.cancel()
+	return s.body.Close()
+}
+
+// retriable tells whether another server could answer the request instead.
+// Failures that happen before the request reaches the node, or that are
+// specific to the node's data, are safe to retry elsewhere.
+func (r *response) retriable() bool {
+	switch r.failure {
+	case PrunedHeight, NotIndexed, ConnRefused, DNSFailure, TLSFailure, RateLimited:
+		return true
+	}
+	return false
+}
+
+// writeTo writes the response, without the CORS headers of the server, as the
+// proxy has its own CORS policy.
+func (r *response) writeTo(w http.ResponseWriter) {
+	for k, v := range r.header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), "Access-Control-") {
+			continue
+		}
+		for _, vv := range v {
+			w.Header().Add(k, vv)
+		}
+	}
+	w.WriteHeader(r.status)
+	if r.stream != nil {
+		defer r.stream.Close()
+		_, _ = io.Copy(w, r.stream)
+		return
+	}
+	_, _ = w.Write(r.body)
+}
+
+// clone copies the response so its headers can be changed independently. The
+// body is never changed, so it's shared.
+func (r *response) clone() *response {
+	c := *r
+	c.header = r.header.Clone()
+	return &c
+}
@@ -1,62 +1,212 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/akash-network/rpc-proxy/internal/avg"
 	"github.com/akash-network/rpc-proxy/internal/config"
-	"github.com/akash-network/rpc-proxy/internal/ttlslice"
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
+	"github.com/akash-network/rpc-proxy/internal/window"
 )
 
-func newServer(name, addr string, cfg config.Config) (*Server, error) {
+func newServer(name, addr string, kind ProxyKind, cfg config.Config) (*Server, error) {
 	target, err := url.Parse(addr)
 	if err != nil {
 		return nil, fmt.Errorf("could not create new server: %w", err)
 	}
-	return &Server{
-		name:      name,
-		url:       target,
-		pings:     avg.Moving(50),
-		cfg:       cfg,
-		successes: ttlslice.New[int](),
-		failures:  ttlslice.New[int](),
-	}, nil
+	srv := &Server{
+		name:    name,
+		url:     target,
+		kind:    kind,
+		pings:   avg.Moving(50),
+		latency: avg.Quantiles(cfg.LatencyWindow),
+		cfg:     cfg,
+		results: window.New(cfg.HealthyErrorRateBucketTimeout),
+	}
+	srv.startWarmup()
+	srv.refreshHealth()
+	return srv, nil
 }
 
 type Server struct {
 	cfg          config.Config
 	name         string
 	url          *url.URL
+	kind         ProxyKind
 	pings        *avg.MovingAverage
-	successes    *ttlslice.Slice[int]
-	failures     *ttlslice.Slice[int]
+	latency      *avg.QuantileWindow
+	results      *window.Counter
 	requestCount atomic.Int64
+	warmStart    atomic.Int64
+	warmRequests atomic.Int64
+	ejectedUntil atomic.Int64
+	coolingUntil atomic.Int64
+	ejections    atomic.Int64
+	failures     [failureKinds]atomic.Int64
+	removed      atomic.Bool
+	// recovering tells whether the server was unhealthy, so it warms up
+	// again once it's healthy.
+	recovering atomic.Bool
+	// health, latency and weight as of the last refreshHealth, so picking a
+	// server doesn't compute them.
+	healthy     atomic.Bool
+	lastLatency atomic.Int64
+	lastWeight  atomic.Uint64
+
+	// retained block range, 0 if unknown.
+	earliestHeight atomic.Int64
+	latestHeight   atomic.Int64
+
+	capsMu       sync.Mutex
+	caps         Capability
+	knownCaps    Capability
+	capsProbedAt atomic.Int64
+
+	affinityHits       atomic.Int64
+	affinityMigrations atomic.Int64
+	broadcasts         atomic.Int64
+
+	// request budgets of the server and its provider, nil if unlimited.
+	budget         *ratelimit.Budget
+	providerBudget *ratelimit.Budget
+	// how many requests were sent to other servers as this one was over
+	// budget.
+	spillovers atomic.Int64
+}
+
+// minWarmupWeight is the share of traffic a server gets when it just started
+// warming up.
+const minWarmupWeight = 0.1
+
+func (s *Server) startWarmup() {
+	s.warmStart.Store(time.Now().UnixNano())
+	s.warmRequests.Store(0)
+}
+
+// WarmedUp tells whether the server served enough requests for a long enough
+// time since it was added or recovered.
+func (s *Server) WarmedUp() bool {
+	return s.warmRequests.Load() >= max(1, int64(s.cfg.SlowStartRequests)) &&
+		time.Since(time.Unix(0, s.warmStart.Load())) >= s.cfg.SlowStartDuration
+}
+
+// weight returns the fraction (0-1) of its round robin share of requests the
+// server should get, ramping up linearly during slow start.
+func (s *Server) weight() float64 {
+	if s.WarmedUp() || s.cfg.SlowStartDuration <= 0 {
+		return 1
+	}
+	elapsed := time.Since(time.Unix(0, s.warmStart.Load()))
+	return min(1, max(minWarmupWeight, float64(elapsed)/float64(s.cfg.SlowStartDuration)))
 }
 
 func (s *Server) ErrorRate() float64 {
-	suss := len(s.successes.List())
-	fail := len(s.failures.List())
-	total := suss + fail
-	if total == 0 {
-		return 0
+	return s.results.Rate()
+}
+
+// Latency returns the configured health percentile of recent request
+// latencies.
+func (s *Server) Latency() time.Duration {
+	return s.latency.Quantile(s.cfg.HealthyPercentile / 100)
+}
+
+// record counts the request outcome. Only failures that are the node fault
+// count against its error rate.
+func (s *Server) record(f Failure) {
+	s.failures[f].Add(1)
+	switch {
+	case f.NodeFault():
+		s.results.Failure()
+	case f != Canceled:
+		s.results.Success()
+	}
+}
+
+// Failures returns how many requests failed, by kind of failure.
+func (s *Server) Failures() map[string]int64 {
+	result := map[string]int64{}
+	for f := NoFailure + 1; f < failureKinds; f++ {
+		if n := s.failures[f].Load(); n > 0 {
+			result[f.String()] = n
+		}
 	}
-	return (float64(fail) * 100) / float64(total)
+	return result
+}
+
+// available tells whether the server is in the pool and in a good shape to
+// get requests.
+func (s *Server) available() bool {
+	return !s.removed.Load() && !s.Ejected() && s.CoolingOff() == 0 && !s.overBudget() && s.healthy.Load()
 }
 
 func (s *Server) Healthy() bool {
-	return s.pings.Last() < s.cfg.HealthyThreshold &&
+	return s.Latency() < s.cfg.HealthyThreshold &&
 		s.ErrorRate() < s.cfg.HealthyErrorRateThreshold
 }
 
-func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// refreshHealth computes and caches the health, latency and weight of the
+// server. Slow samples and failures decay out of their windows, so servers
+// recover as trial requests succeed, and warm up again once they do.
+func (s *Server) refreshHealth() {
+	latency := s.Latency()
+	healthy := latency < s.cfg.HealthyThreshold && s.ErrorRate() < s.cfg.HealthyErrorRateThreshold
+	if !healthy {
+		s.recovering.Store(true)
+	} else if s.recovering.CompareAndSwap(true, false) {
+		slog.Info("server recovered", "name", s.name)
+		s.startWarmup()
+	}
+	s.lastLatency.Store(int64(latency))
+	s.lastWeight.Store(math.Float64bits(s.weight()))
+	s.healthy.Store(healthy)
+}
+
+// errTooLarge is returned when a response is larger than MAX_RESPONSE_SIZE.
+var errTooLarge = errors.New("response too large")
+
+// readBody reads a response body of at most limit bytes, if limit is set. If
+// the body is larger, what was read is returned along with errTooLarge.
+func readBody(body io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(body)
+	}
+	bts, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err == nil && int64(len(bts)) > limit {
+		return bts, errTooLarge
+	}
+	return bts, err
+}
+
+// streams tells whether the response can be streamed to the client right away
+// as nothing else reads it: it isn't inspected, cached nor told apart by
+// JSON-RPC call, and it won't be retried on another server as the node isn't
+// rate limiting.
+func (s *Server) streams(r *request, result *response) bool {
+	if !r.stream || r.read || s.cfg.InspectResponses {
+		return false
+	}
+	_, throttled := s.backoff(result.status, result.header, time.Now())
+	return !throttled
+}
+
+// do proxies the request to the server, buffering the whole response when it
+// needs to be read, e.g. to be inspected or retried on another server, and
+// streaming it otherwise. Responses too large to be read are streamed too,
+// unread, if only the client needs them.
+func (s *Server) do(r *request) *response {
 	var status int = -1
+	s.spend()
 	start := time.Now()
 	defer func() {
 		d := time.Since(start)
@@ -64,54 +214,78 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		slog.Info("request done", "name", s.name, "avg", avg, "last", d, "status", status)
 	}()
 
-	path := r.URL.Path
-	proxiedURL := r.URL
-	proxiedURL.Path = s.url.Path + path
+	proxiedURL := *r.URL
+	proxiedURL.Path = s.url.Path + r.URL.Path
 	proxiedURL.Host = s.url.Host
 	proxiedURL.Scheme = s.url.Scheme
 
-	slog.Info("proxying request", "name", s.name, "url", proxiedURL)
+	slog.Info("proxying request", "name", s.name, "url", &proxiedURL)
 
 	rr := &http.Request{
 		Method:        r.Method,
-		URL:           proxiedURL,
+		URL:           &proxiedURL,
 		Header:        r.Header,
-		Body:          r.Body,
-		ContentLength: r.ContentLength,
+		Body:          http.NoBody,
+		ContentLength: int64(len(r.body)),
 		Close:         r.Close,
 	}
+	if len(r.body) > 0 {
+		rr.Body = io.NopCloser(bytes.NewReader(r.body))
+	}
 
+	result := &response{server: s}
 	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.ProxyRequestTimeout)
-	defer cancel()
+	defer func() {
+		// streams end the request once closed.
+		if result.stream == nil {
+			cancel()
+		}
+	}()
 
 	resp, err := http.DefaultClient.Do(rr.WithContext(ctx))
-	if resp != nil {
-		status = resp.StatusCode
-	}
 	if err == nil {
-		defer resp.Body.Close()
-		for k, v := range resp.Header {
-			for _, vv := range v {
-				w.Header().Set(k, vv)
+		status = resp.StatusCode
+		result.status = resp.StatusCode
+		result.header = resp.Header
+		if s.streams(r, result) {
+			result.stream = &stream{Reader: resp.Body, body: resp.Body, cancel: cancel}
+		} else {
+			result.body, err = readBody(resp.Body, s.cfg.MaxResponseSize)
+			if errors.Is(err, errTooLarge) && r.stream {
+				// too large to be read, but the client can still get it.
+				slog.Warn("response is too large to be read, streaming it", "name", s.name)
+				result.stream = &stream{Reader: io.MultiReader(bytes.NewReader(result.body), resp.Body), body: resp.Body, cancel: cancel}
+				result.body, err = nil, nil
+			} else {
+				_ = resp.Body.Close()
 			}
 		}
-		_, _ = io.Copy(w, resp.Body)
-	} else {
+	}
+	result.latency = time.Since(start)
+	s.latency.Next(result.latency)
+	result.failure = classify(status, err)
+	if err != nil {
 		slog.Error("could not proxy request", "err", err)
-		http.Error(w, "could not proxy request", http.StatusInternalServerError)
+		failed := upstreamError(result.failure).response(s.kind, callID(r))
+		result.status, result.header, result.body = failed.status, failed.header, failed.body
 	}
-
-	s.requestCount.Add(1)
-	if status == 0 || (status >= 200 && status <= 300) {
-		s.successes.Append(status, s.cfg.HealthyErrorRateBucketTimeout)
-	} else {
-		s.failures.Append(status, s.cfg.HealthyErrorRateBucketTimeout)
+	if err == nil && s.throttled(result) {
+		result.failure = RateLimited
 	}
-
-	if !s.Healthy() && ctx.Err() == nil && err == nil {
-		// if it's not healthy, this is a tryout to improve - if the request
-		// wasn't canceled, reset stats
-		slog.Info("resetting statistics", "name", s.name)
-		s.pings.Reset()
+	if err == nil && s.cfg.InspectResponses && result.stream == nil {
+		if f := inspect(s.kind, result.body); f != NoFailure {
+			slog.Warn("node replied with an error", "name", s.name, "failure", f)
+			result.failure = f
+		}
 	}
+	s.learn(r, result)
+	if result.height = parseHeight(result.header.Get(restHeightHeader)); result.height == 0 {
+		result.height = s.latestHeight.Load()
+	}
+
+	s.requestCount.Add(1)
+	s.warmRequests.Add(1)
+	s.record(result.failure)
+	s.refreshHealth()
+	return result
 }
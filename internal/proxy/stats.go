@@ -1,15 +1,69 @@
 package proxy
 
-import "time"
+import (
+	"time"
+
+	"github.com/akash-network/rpc-proxy/internal/admission"
+)
 
 type ServerStat struct {
 	Name        string
 	URL         string
 	Avg         time.Duration
+	P50         time.Duration
+	P90         time.Duration
+	P99         time.Duration
 	Degraded    bool
+	Ejected     bool
+	CoolingOff  time.Duration
 	Initialized bool
 	Requests    int64
 	ErrorRate   float64
+	Failures    map[string]int64
+	Earliest    int64
+	Latest      int64
+	Unsupported []string
+	StickyHits  int64
+	Migrations  int64
+	Broadcasts  int64
+	// requests sent to the server and its provider in the current budget
+	// window, and their budgets, 0 if unlimited.
+	BudgetUsed         int
+	Budget             int
+	ProviderBudgetUsed int
+	ProviderBudget     int
+	// Spillovers are how many requests went to other servers as this one
+	// was over budget.
+	Spillovers int64
+}
+
+// ProxyStat are the stats of a proxy kind as a whole, rather than per server.
+type ProxyStat struct {
+	CacheHits    int64
+	CacheMisses  int64
+	CacheEntries int
+	CacheBytes   int64
+	Coalesced    int64
+	Methods      []MethodStat
+	PolicyHits   map[string]int64
+	TopLimited   []LimitedClient
+	Admission    admission.Stats
+}
+
+// LimitedClient is a client and how many of its requests were rate limited.
+type LimitedClient struct {
+	Client  string
+	Limited int64
+}
+
+// MethodStat are the stats of a JSON-RPC method.
+type MethodStat struct {
+	Method   string
+	Calls    int64
+	Failures int64
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
 }
 
 type serverStats []ServerStat
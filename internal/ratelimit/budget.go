@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// NewBudget creates a budget of requests per fixed window, e.g. 1000 per
+// minute. Once spent, no more requests fit until the next window.
+func NewBudget(rate Rate) *Budget {
+	return &Budget{rate: rate, now: time.Now}
+}
+
+type Budget struct {
+	rate Rate
+	now  func() time.Time
+
+	mu    sync.Mutex
+	start time.Time
+	used  int
+}
+
+// roll starts a new window if the current one is over.
+func (b *Budget) roll() {
+	now := b.now()
+	if now.Sub(b.start) >= b.rate.Per {
+		b.start = now.Truncate(b.rate.Per)
+		b.used = 0
+	}
+}
+
+// Spent tells whether the budget of the current window is spent.
+func (b *Budget) Spent() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	return b.used >= b.rate.Requests
+}
+
+// Spend counts a request against the budget of the current window.
+func (b *Budget) Spend() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	b.used++
+}
+
+// Used returns how many requests were made in the current window, and how
+// many fit in it.
+func (b *Budget) Used() (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	return b.used, b.rate.Requests
+}
+
+// NewBudgets creates a budget per key, like a provider serving several
+// kinds of requests.
+func NewBudgets(rate Rate) *Budgets {
+	return &Budgets{rate: rate, budgets: map[string]*Budget{}}
+}
+
+type Budgets struct {
+	rate    Rate
+	mu      sync.Mutex
+	budgets map[string]*Budget
+}
+
+// Get returns the budget of the key, nil if budgets are disabled.
+func (b *Budgets) Get(key string) *Budget {
+	if !b.rate.Enabled() {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	budget, ok := b.budgets[key]
+	if !ok {
+		budget = NewBudget(b.rate)
+		b.budgets[key] = budget
+	}
+	return budget
+}
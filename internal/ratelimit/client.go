@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefixes parses IPs and CIDRs, skipping invalid ones.
+func ParsePrefixes(addrs []string) []netip.Prefix {
+	var result []netip.Prefix
+	for _, addr := range addrs {
+		if prefix, err := netip.ParsePrefix(addr); err == nil {
+			result = append(result, prefix)
+			continue
+		}
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			slog.Error("invalid trusted proxy", "addr", addr, "err", err)
+			continue
+		}
+		result = append(result, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	return result
+}
+
+func trusted(ip netip.Addr, proxies []netip.Prefix) bool {
+	for _, prefix := range proxies {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// FromTrusted tells whether the request comes from a trusted proxy, so the
+// headers it sets about the client can be relied on.
+func FromTrusted(r *http.Request, proxies []netip.Prefix) bool {
+	ip, err := netip.ParseAddr(remoteHost(r))
+	return err == nil && trusted(ip, proxies)
+}
+
+// ClientIP returns the IP of the client. If the request comes from a trusted
+// proxy, the client IP is the last one in X-Forwarded-For that isn't a trusted
+// proxy, or X-Real-IP.
+func ClientIP(r *http.Request, proxies []netip.Prefix) string {
+	host := remoteHost(r)
+	if !FromTrusted(r, proxies) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			break
+		}
+		if !trusted(ip, proxies) {
+			return ip.Unmap().String()
+		}
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap().String()
+	}
+	return host
+}
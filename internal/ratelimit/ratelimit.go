@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a number of requests per period, e.g. 20/s or 600/m. Clients can
+// burst up to the whole number of requests at once.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+func (r *Rate) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if s == "" || s == "0" {
+		*r = Rate{}
+		return nil
+	}
+	n, unit, ok := strings.Cut(s, "/")
+	requests, err := strconv.Atoi(n)
+	per, known := units[unit]
+	if !ok || err != nil || requests < 0 || !known {
+		return fmt.Errorf("invalid rate %q, expected requests per s, m or h, e.g. 20/s", s)
+	}
+	*r = Rate{Requests: requests, Per: per}
+	return nil
+}
+
+func (r Rate) String() string {
+	if r.Requests == 0 {
+		return "0"
+	}
+	for unit, per := range units {
+		if per == r.Per {
+			return fmt.Sprintf("%d/%s", r.Requests, unit)
+		}
+	}
+	return fmt.Sprintf("%d/%s", r.Requests, r.Per)
+}
+
+// Enabled tells whether the rate limits anything.
+func (r Rate) Enabled() bool { return r.Requests > 0 && r.Per > 0 }
+
+// Result is the outcome of taking tokens from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the tokens requested are available, if
+	// they weren't.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a limiter keeping a token bucket per client.
+func New(rate Rate) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+type Limiter struct {
+	rate    Rate
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// perToken is how long it takes for a token to be added to a bucket.
+func (l *Limiter) perToken() time.Duration {
+	return l.rate.Per / time.Duration(l.rate.Requests)
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	b.tokens = min(float64(l.rate.Requests), b.tokens+float64(now.Sub(b.last))/float64(l.perToken()))
+	b.last = now
+}
+
+// Allow takes n tokens from the client's bucket if it has them.
+func (l *Limiter) Allow(key string, n int) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Requests), last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	result := Result{Limit: l.rate.Requests}
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((float64(n) - b.tokens) * float64(l.perToken()))
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = time.Duration((float64(l.rate.Requests) - b.tokens) * float64(l.perToken()))
+	return result
+}
+
+// Cleanup forgets the clients whose bucket is full again.
+func (l *Limiter) Cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.rate.Requests) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns how many clients are tracked.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
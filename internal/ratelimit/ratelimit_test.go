@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRate(t *testing.T) {
+	for text, expected := range map[string]Rate{
+		"":      {},
+		"0":     {},
+		"20/s":  {20, time.Second},
+		"600/m": {600, time.Minute},
+		"10/h":  {10, time.Hour},
+	} {
+		var rate Rate
+		require.NoError(t, rate.UnmarshalText([]byte(text)), text)
+		require.Equal(t, expected, rate, text)
+	}
+	for _, text := range []string{"20", "20/d", "a/s", "-1/s"} {
+		var rate Rate
+		require.Error(t, rate.UnmarshalText([]byte(text)), text)
+	}
+	require.Equal(t, "600/m", Rate{600, time.Minute}.String())
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New(Rate{2, time.Second})
+	l.now = func() time.Time { return now }
+
+	res := l.Allow("a", 1)
+	require.True(t, res.Allowed)
+	require.Equal(t, 2, res.Limit)
+	require.Equal(t, 1, res.Remaining)
+	require.True(t, l.Allow("a", 1).Allowed)
+
+	res = l.Allow("a", 1)
+	require.False(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+	require.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	require.Equal(t, time.Second, res.Reset)
+
+	// other clients have their own bucket.
+	require.True(t, l.Allow("b", 2).Allowed)
+	require.False(t, l.Allow("b", 1).Allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, l.Allow("a", 1).Allowed)
+	require.False(t, l.Allow("a", 1).Allowed)
+
+	// batches take several tokens.
+	now = now.Add(time.Second)
+	require.False(t, l.Allow("a", 3).Allowed)
+	require.True(t, l.Allow("a", 2).Allowed)
+
+	now = now.Add(time.Second)
+	require.Equal(t, 2, l.Len())
+	l.Cleanup()
+	require.Zero(t, l.Len())
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := ParsePrefixes([]string{"10.0.0.0/8", "192.168.1.1", "invalid"})
+	require.Len(t, proxies, 2)
+
+	for _, tt := range []struct {
+		name     string
+		remote   string
+		headers  map[string]string
+		expected string
+	}{
+		{"direct", "1.2.3.4:1234", nil, "1.2.3.4"},
+		{"untrusted forwarded", "1.2.3.4:1234", map[string]string{"X-Forwarded-For": "5.6.7.8"}, "1.2.3.4"},
+		{"trusted forwarded", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "5.6.7.8"}, "5.6.7.8"},
+		{"spoofed forwarded", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 5.6.7.8, 192.168.1.1"}, "5.6.7.8"},
+		{"real ip", "192.168.1.1:1234", map[string]string{"X-Real-IP": "5.6.7.8"}, "5.6.7.8"},
+		{"invalid real ip", "192.168.1.1:1234", map[string]string{"X-Real-IP": "5.6.7.8, 6.6.6.6"}, "192.168.1.1"},
+		{"trusted without headers", "10.0.0.1:1234", nil, "10.0.0.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			require.Equal(t, tt.expected, ClientIP(r, proxies))
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBudget(Rate{Requests: 2, Per: time.Minute})
+	b.now = func() time.Time { return now }
+
+	require.False(t, b.Spent())
+	b.Spend()
+	b.Spend()
+	require.True(t, b.Spent())
+	used, limit := b.Used()
+	require.Equal(t, 2, used)
+	require.Equal(t, 2, limit)
+
+	// spent for the remainder of the window.
+	now = now.Add(59 * time.Second)
+	require.True(t, b.Spent())
+	now = now.Add(time.Second)
+	require.False(t, b.Spent())
+	used, _ = b.Used()
+	require.Zero(t, used)
+}
+
+func TestBudgets(t *testing.T) {
+	require.Nil(t, NewBudgets(Rate{}).Get("provider"))
+	b := NewBudgets(Rate{Requests: 1, Per: time.Second})
+	require.Same(t, b.Get("provider"), b.Get("provider"))
+	require.NotSame(t, b.Get("provider"), b.Get("other"))
+}
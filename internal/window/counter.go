@@ -0,0 +1,86 @@
+package window
+
+import (
+	"sync"
+	"time"
+)
+
+const buckets = 12
+
+type bucket struct {
+	idx      int64
+	success  uint64
+	failures uint64
+}
+
+// New creates a Counter tracking successes and failures over the given
+// sliding window.
+//
+// The window is split into fixed time buckets that are recycled as time
+// passes, so the counter never allocates after creation nor needs a
+// background goroutine to expire old entries.
+func New(window time.Duration) *Counter {
+	width := window / buckets
+	if width <= 0 {
+		width = 1
+	}
+	return &Counter{
+		width: width,
+		now:   time.Now,
+	}
+}
+
+type Counter struct {
+	mu      sync.Mutex
+	width   time.Duration
+	buckets [buckets]bucket
+	now     func() time.Time
+}
+
+func (c *Counter) Success() { c.add(true) }
+func (c *Counter) Failure() { c.add(false) }
+
+func (c *Counter) add(ok bool) {
+	idx := c.now().UnixNano() / int64(c.width)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := &c.buckets[idx%buckets]
+	if b.idx != idx {
+		*b = bucket{idx: idx}
+	}
+	if ok {
+		b.success++
+	} else {
+		b.failures++
+	}
+}
+
+// Counts returns the successes and failures within the window.
+func (c *Counter) Counts() (success, failures uint64) {
+	idx := c.now().UnixNano() / int64(c.width)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.buckets {
+		if b := &c.buckets[i]; b.idx > idx-buckets {
+			success += b.success
+			failures += b.failures
+		}
+	}
+	return success, failures
+}
+
+// Rate returns the percentage (0-100) of failures within the window.
+func (c *Counter) Rate() float64 {
+	success, failures := c.Counts()
+	total := success + failures
+	if total == 0 {
+		return 0
+	}
+	return (float64(failures) * 100) / float64(total)
+}
+
+func (c *Counter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buckets = [buckets]bucket{}
+}
@@ -0,0 +1,85 @@
+package window
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		c := New(time.Minute)
+		require.Zero(t, c.Rate())
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		c := New(time.Minute)
+		for i := 0; i < 3; i++ {
+			c.Success()
+		}
+		c.Failure()
+		require.Equal(t, float64(25), c.Rate())
+		success, failures := c.Counts()
+		require.Equal(t, uint64(3), success)
+		require.Equal(t, uint64(1), failures)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		now := time.Now()
+		c := New(time.Minute)
+		c.now = func() time.Time { return now }
+		c.Failure()
+
+		now = now.Add(30 * time.Second)
+		c.Success()
+		require.Equal(t, float64(50), c.Rate())
+
+		now = now.Add(35 * time.Second)
+		require.Zero(t, c.Rate())
+		success, _ := c.Counts()
+		require.Equal(t, uint64(1), success)
+
+		now = now.Add(time.Minute)
+		success, failures := c.Counts()
+		require.Zero(t, success)
+		require.Zero(t, failures)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		c := New(time.Minute)
+		c.Failure()
+		require.NotZero(t, c.Rate())
+		c.Reset()
+		require.Zero(t, c.Rate())
+	})
+}
+
+func BenchmarkCounter(b *testing.B) {
+	b.Run("add", func(b *testing.B) {
+		c := New(time.Minute)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				if i%10 == 0 {
+					c.Failure()
+				} else {
+					c.Success()
+				}
+				i++
+			}
+		})
+	})
+
+	b.Run("add-and-rate", func(b *testing.B) {
+		c := New(time.Minute)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				c.Success()
+				_ = c.Rate()
+			}
+		})
+	})
+}
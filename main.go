@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "embed"
 	"errors"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
@@ -12,8 +13,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/akash-network/rpc-proxy/internal/auth"
 	"github.com/akash-network/rpc-proxy/internal/config"
 	"github.com/akash-network/rpc-proxy/internal/proxy"
+	"github.com/akash-network/rpc-proxy/internal/ratelimit"
 	"github.com/akash-network/rpc-proxy/internal/seed"
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -21,8 +24,18 @@ import (
 //go:embed index.html
 var index []byte
 
+// kindStats are the stats rendered in the index for each kind of proxy.
+type kindStats struct {
+	Servers []proxy.ServerStat
+	Proxy   proxy.ProxyStat
+}
+
 func main() {
-	cfg := config.Must()
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		os.Exit(1)
+	}
 
 	am := autocert.Manager{
 		Cache:  autocert.DirCache("."),
@@ -38,12 +51,25 @@ func main() {
 	rpcListener := make(chan seed.Seed, 1)
 	restListener := make(chan seed.Seed, 1)
 
-	updater := seed.New(cfg, rpcListener, restListener)
-	rpcProxyHandler := proxy.New(proxy.RPC, rpcListener, cfg)
-	restProxyHandler := proxy.New(proxy.Rest, restListener, cfg)
-
 	ctx, proxyCtxCancel := context.WithCancel(context.Background())
 	defer proxyCtxCancel()
+
+	opts := []proxy.Option{proxy.WithProviderBudgets(ratelimit.NewBudgets(cfg.ProviderRequestBudget))}
+	var keys *auth.Store
+	if cfg.APIKeysFile != "" {
+		keys, err = auth.Load(cfg.APIKeysFile)
+		if err != nil {
+			slog.Error("could not load api keys", "err", err)
+			os.Exit(1)
+		}
+		keys.Watch(ctx, cfg.APIKeysReloadInterval)
+		opts = append(opts, proxy.WithAuth(keys))
+	}
+
+	updater := seed.New(cfg, rpcListener, restListener)
+	rpcProxyHandler := proxy.New(proxy.RPC, rpcListener, cfg, opts...)
+	restProxyHandler := proxy.New(proxy.Rest, restListener, cfg, opts...)
+
 	updater.Start(ctx)
 	rpcProxyHandler.Start(ctx)
 	restProxyHandler.Start(ctx)
@@ -61,12 +87,15 @@ func main() {
 			w.WriteHeader(http.StatusServiceUnavailable)
 		}
 	}))
+	if keys != nil && cfg.AdminToken != "" {
+		m.Handle("/admin/usage", keys.UsageHandler(cfg.AdminToken))
+	}
 	m.Handle("/rpc", rpcProxyHandler)
 	m.Handle("/rest", restProxyHandler)
 	m.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := indexTpl.Execute(w, map[string][]proxy.ServerStat{
-			"RPC":  rpcProxyHandler.Stats(),
-			"Rest": restProxyHandler.Stats(),
+		if err := indexTpl.Execute(w, map[string]kindStats{
+			"RPC":  {rpcProxyHandler.Stats(), rpcProxyHandler.ProxyStats()},
+			"Rest": {restProxyHandler.Stats(), restProxyHandler.ProxyStats()},
 		}); err != nil {
 			slog.Error("could render stats", "err", err)
 		}